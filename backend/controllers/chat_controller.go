@@ -1,8 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"strconv"
+	"strings"
+	"time"
 
 	"web/ai-playground/models"
 	"web/ai-playground/services"
@@ -12,7 +17,7 @@ import (
 )
 
 type ChatController struct {
-	openRouterService *services.OpenRouterService
+	chatService *services.ChatService
 }
 
 type ForkResponse struct {
@@ -22,9 +27,9 @@ type ForkResponse struct {
 	CreatedAt      string `json:"createdAt"`
 }
 
-func NewChatController(openRouterService *services.OpenRouterService) *ChatController {
+func NewChatController(chatService *services.ChatService) *ChatController {
 	return &ChatController{
-		openRouterService: openRouterService,
+		chatService: chatService,
 	}
 }
 
@@ -35,47 +40,117 @@ func (cc *ChatController) HandleChat(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Create or get existing chat
-	var chat models.Chat
-	isNewChat := true
-
-	// If we have a chat ID in the request, try to find the existing chat
-	if chatReq.ChatID != 0 {
-		isNewChat = false
-		if err := cc.openRouterService.DB.Preload("Messages").First(&chat, chatReq.ChatID).Error; err != nil {
-			fmt.Printf("Error finding chat: %v\n", err)
-			c.JSON(500, gin.H{"error": err.Error()})
+	if chatReq.Provider != "" {
+		if _, err := cc.chatService.ResolveProvider(chatReq.Provider, "", ""); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 	}
+	if _, err := cc.chatService.Tools.Resolve(chatReq.Tools); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// An attachment can be sent only once, so the IDs are checked across all
+	// messages of the request
+	var attachmentIDs []uint
+	for _, msg := range chatReq.Messages {
+		attachmentIDs = append(attachmentIDs, msg.AttachmentIDs...)
+	}
+	if err := cc.chatService.CheckAttachments(attachmentIDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if chatReq.ServerHistory {
+		for _, msg := range chatReq.Messages {
+			if msg.ID != 0 {
+				c.JSON(400, gin.H{"error": "With server_history, send only new messages"})
+				return
+			}
+		}
+	}
 
-	if isNewChat {
-		chat = models.Chat{
-			ModelName: chatReq.Model,
+	fmt.Printf("Received request for model: %s\n", chatReq.Model)
+
+	if !chatReq.Stream {
+		result, err := cc.chatService.ChatSync(c.Request.Context(), chatReq, chatReq.ChatID)
+		// New chats are only created once the request has been checked
+		if result != nil && chatReq.ChatID == 0 {
+			c.Header("X-Chat-ID", fmt.Sprintf("%d", result.ChatID))
+		}
+		if errors.Is(err, services.ErrInvalidParams) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
-		if err := cc.openRouterService.DB.Create(&chat).Error; err != nil {
-			fmt.Printf("Error creating chat: %v\n", err)
+		if err != nil {
+			fmt.Printf("Error from chat service: %v\n", err)
+			// Name the saved messages so the client can show or retry the failed answer
+			if result != nil {
+				c.JSON(500, gin.H{
+					"error":              err.Error(),
+					"chatId":             result.ChatID,
+					"userMessageId":      result.UserMessageID,
+					"assistantMessageId": result.AssistantMessageID,
+				})
+				return
+			}
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		// Set chat ID in response header for new chats
-		c.Header("X-Chat-ID", fmt.Sprintf("%d", chat.ID))
+		c.JSON(200, result)
+		return
 	}
 
-	fmt.Printf("Received request for model: %s\n", chatReq.Model)
-
-	if chatReq.Stream {
-		c.Header("Content-Type", "text/event-stream")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
+	if err := cc.chatService.Chat(c.Request.Context(), chatReq, chatReq.ChatID, c.Writer); err != nil {
+		fmt.Printf("Error from chat service: %v\n", err)
+		// Once the event stream has started, failures reach the client as
+		// error events instead
+		if !c.Writer.Written() {
+			status := 500
+			if errors.Is(err, services.ErrInvalidParams) {
+				status = 400
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+		}
+		return
 	}
+}
 
-	if err := cc.openRouterService.Chat(chatReq, chat.ID, c.Writer); err != nil {
-		fmt.Printf("Error from OpenRouter service: %v\n", err)
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+func (cc *ChatController) HandleGetModels(c *gin.Context) {
+	filter := services.ModelFilter{
+		Provider: c.Query("provider"),
+		Modality: c.Query("modality"),
+	}
+	if v := c.Query("min_context"); v != "" {
+		var err error
+		if filter.MinContextLength, err = strconv.Atoi(v); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid min_context parameter"})
+			return
+		}
+	}
+	if v := c.Query("max_prompt_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid max_prompt_price parameter"})
+			return
+		}
+		filter.MaxPromptPrice = &price
+	}
+	if v := c.Query("max_completion_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid max_completion_price parameter"})
+			return
+		}
+		filter.MaxCompletionPrice = &price
 	}
+	refresh := c.Query("refresh") == "true"
+
+	c.JSON(200, gin.H{"data": cc.chatService.ListModels(c.Request.Context(), filter, refresh)})
+}
+
+// HandleGetTools lists the server tools chats can enable.
+func (cc *ChatController) HandleGetTools(c *gin.Context) {
+	c.JSON(200, gin.H{"data": cc.chatService.Tools.List()})
 }
 
 func (cc *ChatController) HandleGetChats(c *gin.Context) {
@@ -92,7 +167,7 @@ func (cc *ChatController) HandleGetChats(c *gin.Context) {
 
 	var chats []models.Chat
 	// Get the first message for preview and count all messages
-	if err := cc.openRouterService.DB.
+	if err := cc.chatService.DB.
 		Preload("Messages", func(db *gorm.DB) *gorm.DB {
 			return db.Order("created_at ASC")
 		}).
@@ -108,7 +183,7 @@ func (cc *ChatController) HandleGetChats(c *gin.Context) {
 
 	// Get total count for pagination - only count root chats
 	var total int64
-	if err := cc.openRouterService.DB.Model(&models.Chat{}).Where("parent_id IS NULL").Count(&total).Error; err != nil {
+	if err := cc.chatService.DB.Model(&models.Chat{}).Where("parent_id IS NULL").Count(&total).Error; err != nil {
 		fmt.Printf("Error counting chats: %v\n", err)
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -120,7 +195,7 @@ func (cc *ChatController) HandleGetChats(c *gin.Context) {
 		Count  int64
 	}
 	var chatCounts []ChatCount
-	if err := cc.openRouterService.DB.Model(&models.Message{}).
+	if err := cc.chatService.DB.Model(&models.Message{}).
 		Select("chat_id, count(*) as count").
 		Group("chat_id").
 		Find(&chatCounts).Error; err != nil {
@@ -160,11 +235,95 @@ func (cc *ChatController) HandleGetChats(c *gin.Context) {
 	})
 }
 
+// HandleSearch finds messages by their content, filtered by role, model,
+// starred, fork and creation date.
+func (cc *ChatController) HandleSearch(c *gin.Context) {
+	opts := services.SearchOptions{
+		Query: c.Query("q"),
+		Role:  c.Query("role"),
+		Model: c.Query("model"),
+	}
+	var err error
+	if opts.Starred, err = boolQuery(c, "starred"); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid starred parameter"})
+		return
+	}
+	if opts.Fork, err = boolQuery(c, "fork"); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid fork parameter"})
+		return
+	}
+	if opts.From, err = timeQuery(c, "from", false); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid from parameter, expected RFC 3339 or YYYY-MM-DD"})
+		return
+	}
+	if opts.To, err = timeQuery(c, "to", true); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid to parameter, expected RFC 3339 or YYYY-MM-DD"})
+		return
+	}
+	if opts.Page, err = strconv.Atoi(c.DefaultQuery("page", "1")); err != nil || opts.Page < 1 {
+		opts.Page = 1
+	}
+
+	results, total, err := cc.chatService.SearchMessages(opts)
+	if errors.Is(err, services.ErrEmptySearch) {
+		c.JSON(400, gin.H{"error": "q is required"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error searching messages: %v\n", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"results":  results,
+		"total":    total,
+		"page":     opts.Page,
+		"pageSize": services.SearchPageSize,
+		"hasMore":  (opts.Page-1)*services.SearchPageSize+len(results) < int(total),
+	})
+}
+
+// boolQuery parses an optional true/false query parameter.
+func boolQuery(c *gin.Context, name string) (*bool, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// timeQuery parses an optional RFC 3339 timestamp or date query parameter
+// into the format timestamps are stored in. An upper bound is returned as
+// the first moment after it: the next second, or the next day for a date.
+func timeQuery(c *gin.Context, name string, upper bool) (string, error) {
+	v := c.Query(name)
+	if v == "" {
+		return "", nil
+	}
+	step := time.Second
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		if t, err = time.ParseInLocation(time.DateOnly, v, time.Local); err != nil {
+			return "", err
+		}
+		step = 24 * time.Hour
+	}
+	if upper {
+		t = t.Add(step)
+	}
+	return t.Local().Format(time.RFC3339), nil
+}
+
 func (cc *ChatController) HandleGetChat(c *gin.Context) {
 	chatID := c.Param("id")
 	var chat models.Chat
 
-	if err := cc.openRouterService.DB.Preload("Messages").First(&chat, chatID).Error; err != nil {
+	if err := cc.chatService.DB.Preload("Messages.Attachments").First(&chat, chatID).Error; err != nil {
 		c.JSON(404, gin.H{"error": "Chat not found"})
 		return
 	}
@@ -172,19 +331,199 @@ func (cc *ChatController) HandleGetChat(c *gin.Context) {
 	c.JSON(200, chat)
 }
 
+// HandleExportChat downloads a chat as Markdown, JSON or HTML, with its
+// forks when forks=true.
+func (cc *ChatController) HandleExportChat(c *gin.Context) {
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+	format := c.DefaultQuery("format", "md")
+	contentType, ok := services.ExportContentTypes[format]
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid format parameter, expected md, json or html"})
+		return
+	}
+
+	chat, err := cc.chatService.LoadChatExport(uint(chatID), c.Query("forks") == "true")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(404, gin.H{"error": "Chat not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error loading chat %d for export: %v\n", chatID, err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var body strings.Builder
+	if err := services.RenderChatExport(&body, chat, format); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fmt.Sprintf("chat-%d.%s", chatID, format)}))
+	c.Data(200, contentType, []byte(body.String()))
+}
+
+// HandleExportChats downloads every chat, or the starred ones when
+// starred=true, as a zip of files in the requested format.
+func (cc *ChatController) HandleExportChats(c *gin.Context) {
+	format := c.DefaultQuery("format", "md")
+	if _, ok := services.ExportContentTypes[format]; !ok {
+		c.JSON(400, gin.H{"error": "Invalid format parameter, expected md, json or html"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "chats.zip"}))
+	c.Status(200)
+	// Headers are sent by now, so a failure can only cut the zip short
+	if err := cc.chatService.ExportChats(c.Writer, format, c.Query("starred") == "true", c.Query("forks") == "true"); err != nil {
+		fmt.Printf("Error exporting chats: %v\n", err)
+	}
+}
+
+// HandleImport imports conversations from a ChatGPT, Claude or playground
+// export, sent as the request body or as a "file" form field.
+func (cc *ChatController) HandleImport(c *gin.Context) {
+	body := io.Reader(c.Request.Body)
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Missing file field"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(io.LimitReader(body, cc.chatService.MaxImportBytes+1))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if int64(len(data)) > cc.chatService.MaxImportBytes {
+		c.JSON(413, gin.H{"error": "import too large"})
+		return
+	}
+
+	results, err := cc.chatService.Import(data)
+	if errors.Is(err, services.ErrInvalidImport) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error importing chats: %v\n", err)
+		c.JSON(500, gin.H{"error": err.Error(), "chats": results})
+		return
+	}
+
+	imported := 0
+	for _, result := range results {
+		if !result.Skipped {
+			imported++
+		}
+	}
+	c.JSON(200, gin.H{
+		"imported": imported,
+		"skipped":  len(results) - imported,
+		"chats":    results,
+	})
+}
+
+// HandleUploadAttachment stores the multipart "file" field for a later
+// message. Send the returned ID in the message's attachment_ids.
+func (cc *ChatController) HandleUploadAttachment(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Missing file field"})
+		return
+	}
+	if header.Size > cc.chatService.MaxAttachmentBytes {
+		c.JSON(413, gin.H{"error": services.ErrAttachmentTooLarge.Error()})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := cc.chatService.SaveAttachment(header.Filename, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAttachmentTooLarge):
+			c.JSON(413, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrUnsupportedAttachment):
+			c.JSON(415, gin.H{"error": err.Error()})
+		default:
+			fmt.Printf("Error saving attachment: %v\n", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(200, attachment)
+}
+
+func (cc *ChatController) HandleGetAttachment(c *gin.Context) {
+	var attachment models.Attachment
+	if err := cc.chatService.DB.First(&attachment, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	// Only images and PDFs are shown inline; the rest is downloaded as text,
+	// so an upload can never run as a page on the API's origin
+	contentType, disposition := "text/plain; charset=utf-8", "attachment"
+	if services.IsInlineAttachment(attachment) {
+		contentType, disposition = attachment.ContentType, "inline"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.File(cc.chatService.AttachmentPath(attachment))
+}
+
 func (cc *ChatController) HandleNewChat(c *gin.Context) {
 	var req struct {
-		Model string `json:"model"`
+		Model          string                   `json:"model"`
+		Provider       string                   `json:"provider"`
+		FallbackModels []string                 `json:"fallback_models"`
+		SystemPrompt   string                   `json:"system_prompt"`
+		Params         *models.GenerationParams `json:"params"`
+		Tools          []string                 `json:"tools"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Provider != "" {
+		if _, err := cc.chatService.ResolveProvider(req.Provider, "", ""); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := cc.chatService.Tools.Resolve(req.Tools); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 
 	chat := models.Chat{
-		ModelName: req.Model,
-	}
-	if err := cc.openRouterService.DB.Create(&chat).Error; err != nil {
+		ModelName:      req.Model,
+		ProviderName:   req.Provider,
+		FallbackModels: req.FallbackModels,
+		SystemPrompt:   req.SystemPrompt,
+		DefaultParams:  req.Params,
+		Tools:          req.Tools,
+	}
+	if err := cc.chatService.DB.Create(&chat).Error; err != nil {
 		fmt.Printf("Error creating new chat: %v\n", err)
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -193,11 +532,84 @@ func (cc *ChatController) HandleNewChat(c *gin.Context) {
 	c.JSON(200, gin.H{"id": chat.ID})
 }
 
+// HandleUpdateChat changes a chat's settings. Only the fields present in the
+// body are updated; an empty string or list clears a setting.
+func (cc *ChatController) HandleUpdateChat(c *gin.Context) {
+	chatID := c.Param("id")
+	var req struct {
+		Model          *string                  `json:"model"`
+		Provider       *string                  `json:"provider"`
+		FallbackModels []string                 `json:"fallback_models"`
+		SystemPrompt   *string                  `json:"system_prompt"`
+		Params         *models.GenerationParams `json:"params"`
+		Tools          []string                 `json:"tools"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var chat models.Chat
+	if err := cc.chatService.DB.First(&chat, chatID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Chat not found"})
+		return
+	}
+
+	var fields []string
+	if req.Model != nil {
+		chat.ModelName = *req.Model
+		fields = append(fields, "model_name")
+	}
+	if req.Provider != nil {
+		if *req.Provider != "" {
+			if _, err := cc.chatService.ResolveProvider(*req.Provider, "", ""); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		chat.ProviderName = *req.Provider
+		fields = append(fields, "provider_name")
+	}
+	if req.FallbackModels != nil {
+		chat.FallbackModels = req.FallbackModels
+		fields = append(fields, "fallback_models")
+	}
+	if req.SystemPrompt != nil {
+		chat.SystemPrompt = *req.SystemPrompt
+		fields = append(fields, "system_prompt")
+	}
+	if req.Params != nil {
+		chat.DefaultParams = req.Params
+		fields = append(fields, "default_params")
+	}
+	if req.Tools != nil {
+		if _, err := cc.chatService.Tools.Resolve(req.Tools); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		chat.Tools = req.Tools
+		fields = append(fields, "tools")
+	}
+	if len(fields) == 0 {
+		c.JSON(400, gin.H{"error": "No settings to update"})
+		return
+	}
+
+	fields = append(fields, "updated_at")
+	if err := cc.chatService.DB.Model(&chat).Select(fields).Updates(&chat).Error; err != nil {
+		fmt.Printf("Error updating chat %s: %v\n", chatID, err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, chat)
+}
+
 func (cc *ChatController) HandleToggleChatStar(c *gin.Context) {
 	chatID := c.Param("id")
 	var chat models.Chat
 
-	if err := cc.openRouterService.DB.First(&chat, chatID).Error; err != nil {
+	if err := cc.chatService.DB.First(&chat, chatID).Error; err != nil {
 		c.JSON(404, gin.H{"error": "Chat not found"})
 		return
 	}
@@ -205,7 +617,7 @@ func (cc *ChatController) HandleToggleChatStar(c *gin.Context) {
 	// Toggle the starred status
 	chat.Starred = !chat.Starred
 
-	if err := cc.openRouterService.DB.Save(&chat).Error; err != nil {
+	if err := cc.chatService.DB.Save(&chat).Error; err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -217,7 +629,7 @@ func (cc *ChatController) HandleToggleMessageStar(c *gin.Context) {
 	messageID := c.Param("id")
 	var message models.Message
 
-	if err := cc.openRouterService.DB.First(&message, messageID).Error; err != nil {
+	if err := cc.chatService.DB.First(&message, messageID).Error; err != nil {
 		c.JSON(404, gin.H{"error": "Message not found"})
 		return
 	}
@@ -225,7 +637,7 @@ func (cc *ChatController) HandleToggleMessageStar(c *gin.Context) {
 	// Toggle the starred status
 	message.Starred = !message.Starred
 
-	if err := cc.openRouterService.DB.Save(&message).Error; err != nil {
+	if err := cc.chatService.DB.Save(&message).Error; err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -233,11 +645,52 @@ func (cc *ChatController) HandleToggleMessageStar(c *gin.Context) {
 	c.JSON(200, gin.H{"starred": message.Starred})
 }
 
+func (cc *ChatController) HandleStopGeneration(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	if err := cc.chatService.StopGeneration(uint(messageID)); err != nil {
+		if errors.Is(err, services.ErrGenerationNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"stopped": true})
+}
+
+func (cc *ChatController) HandleStreamMessage(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	after, err := strconv.Atoi(c.DefaultQuery("after", "0"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid after parameter"})
+		return
+	}
+
+	if err := cc.chatService.StreamMessage(c.Request.Context(), uint(messageID), after, c.Writer); err != nil {
+		if errors.Is(err, services.ErrGenerationNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		fmt.Printf("Error streaming message %d: %v\n", messageID, err)
+	}
+}
+
 func (cc *ChatController) HandleDeleteChat(c *gin.Context) {
 	chatID := c.Param("id")
 
 	// Soft delete the chat
-	if err := cc.openRouterService.DB.Delete(&models.Chat{}, chatID).Error; err != nil {
+	if err := cc.chatService.DB.Delete(&models.Chat{}, chatID).Error; err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -261,9 +714,9 @@ func (cc *ChatController) HandleForkChat(c *gin.Context) {
 
 	// Get the original chat
 	var originalChat models.Chat
-	if err := cc.openRouterService.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+	if err := cc.chatService.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
 		return db.Order("created_at ASC")
-	}).First(&originalChat, req.ChatID).Error; err != nil {
+	}).Preload("Messages.Attachments").First(&originalChat, req.ChatID).Error; err != nil {
 		fmt.Printf("Error finding original chat: %v\n", err)
 		c.JSON(404, gin.H{"error": "Original chat not found"})
 		return
@@ -271,12 +724,17 @@ func (cc *ChatController) HandleForkChat(c *gin.Context) {
 
 	// Create new chat as a fork
 	newChat := models.Chat{
-		ModelName:     originalChat.ModelName,
-		ParentID:      &originalChat.ID,
-		ForkMessageID: &req.MessageID,
+		ModelName:      originalChat.ModelName,
+		ProviderName:   originalChat.ProviderName,
+		FallbackModels: originalChat.FallbackModels,
+		SystemPrompt:   originalChat.SystemPrompt,
+		DefaultParams:  originalChat.DefaultParams,
+		Tools:          originalChat.Tools,
+		ParentID:       &originalChat.ID,
+		ForkMessageID:  &req.MessageID,
 	}
 
-	if err := cc.openRouterService.DB.Create(&newChat).Error; err != nil {
+	if err := cc.chatService.DB.Create(&newChat).Error; err != nil {
 		fmt.Printf("Error creating fork: %v\n", err)
 		c.JSON(500, gin.H{"error": "Failed to create forked chat"})
 		return
@@ -284,25 +742,64 @@ func (cc *ChatController) HandleForkChat(c *gin.Context) {
 
 	fmt.Printf("Created new fork chat with ID %d\n", newChat.ID)
 
-	// Copy messages up to the fork point (but don't add the edited message)
+	// Copy messages up to the fork point (but don't add the edited message),
+	// pointing tool results at the copies of their callers
+	copiedIDs := make(map[uint]uint)
 	for _, msg := range originalChat.Messages {
 		if msg.ID == req.MessageID {
 			break
 		}
-		// Copy the message to the new chat
+		// Copy the message to the new chat. An answer still being generated
+		// is copied as it stands, so it ends up cancelled in the fork
+		status := msg.Status
+		if status == models.MessageStatusStreaming {
+			status = models.MessageStatusCancelled
+		}
 		newMsg := models.Message{
 			ChatID:           newChat.ID,
 			Role:             msg.Role,
 			Content:          msg.Content,
+			Reasoning:        msg.Reasoning,
 			ModelName:        msg.ModelName,
 			PromptTokens:     msg.PromptTokens,
 			CompletionTokens: msg.CompletionTokens,
+			ReasoningTokens:  msg.ReasoningTokens,
 			TotalTokens:      msg.TotalTokens,
+			Status:           status,
+			FinishReason:     msg.FinishReason,
+			Error:            msg.Error,
+			ToolCalls:        msg.ToolCalls,
+			ToolCallID:       msg.ToolCallID,
+			ToolName:         msg.ToolName,
+			Params:           msg.Params,
+			GenerationID:     msg.GenerationID,
+			ProviderName:     msg.ProviderName,
+			UpstreamModel:    msg.UpstreamModel,
+			UpstreamProvider: msg.UpstreamProvider,
+			TimeToFirstToken: msg.TimeToFirstToken,
+			Duration:         msg.Duration,
+			TokensPerSecond:  msg.TokensPerSecond,
+		}
+		if msg.CallerMessageID != nil {
+			if callerID, ok := copiedIDs[*msg.CallerMessageID]; ok {
+				newMsg.CallerMessageID = &callerID
+			}
 		}
-		if err := cc.openRouterService.DB.Create(&newMsg).Error; err != nil {
+		if err := cc.chatService.DB.Create(&newMsg).Error; err != nil {
 			c.JSON(500, gin.H{"error": "Failed to copy message"})
 			return
 		}
+		copiedIDs[msg.ID] = newMsg.ID
+
+		// Copies of attachments share the stored file
+		for _, attachment := range msg.Attachments {
+			attachment.BaseModel = models.BaseModel{}
+			attachment.MessageID = &newMsg.ID
+			if err := cc.chatService.DB.Create(&attachment).Error; err != nil {
+				c.JSON(500, gin.H{"error": "Failed to copy attachment"})
+				return
+			}
+		}
 	}
 
 	// Send the new chat ID
@@ -316,7 +813,7 @@ func (cc *ChatController) HandleGetChatForks(c *gin.Context) {
 
 	// Get all forks for this chat
 	var chats []models.Chat
-	err := cc.openRouterService.DB.
+	err := cc.chatService.DB.
 		Where("parent_id = ? AND deleted_at IS NULL", chatID).
 		Order("created_at DESC").
 		Find(&chats).Error
@@ -342,7 +839,7 @@ func (cc *ChatController) HandleGetChatForks(c *gin.Context) {
 		}
 
 		var message models.Message
-		if err := cc.openRouterService.DB.
+		if err := cc.chatService.DB.
 			Where("id = ?", *chat.ForkMessageID).
 			First(&message).Error; err != nil {
 			fmt.Printf("Error fetching message %d: %v\n", *chat.ForkMessageID, err)
@@ -371,7 +868,7 @@ func (cc *ChatController) HandleGetParentForkMessage(c *gin.Context) {
 
 	var message models.Message
 
-	if err := cc.openRouterService.DB.
+	if err := cc.chatService.DB.
 		Where("id = ?", messageID).
 		Preload("Chat").
 		First(&message).Error; err != nil {
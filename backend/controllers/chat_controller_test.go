@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"web/ai-playground/migrations"
+	"web/ai-playground/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testController returns a controller backed by a migrated SQLite database
+// and an attachments directory, both temporary.
+func testController(t *testing.T) *ChatController {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chat.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if _, err := migrations.New(db).Up(0); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	s := services.NewChatService(db, &services.MockProvider{})
+	s.AttachmentsDir = t.TempDir()
+	return NewChatController(s)
+}
+
+func TestHandleGetAttachment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cc := testController(t)
+	router := gin.New()
+	router.GET("/api/attachments/:id", cc.HandleGetAttachment)
+
+	tests := []struct {
+		name            string
+		fileName        string
+		content         string
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			name:            "text is downloaded as plain text",
+			fileName:        "page.md",
+			content:         "# Title\n\nplain text that a browser might sniff",
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `attachment; filename=page.md`,
+		},
+		{
+			name:            "image is shown inline",
+			fileName:        "pixel.png",
+			content:         "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+			wantType:        "image/png",
+			wantDisposition: `inline; filename=pixel.png`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment, err := cc.chatService.SaveAttachment(tt.fileName, strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("SaveAttachment: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/attachments/%d", attachment.ID), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDisposition)
+			}
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+			if w.Body.String() != tt.content {
+				t.Errorf("body = %q, want the uploaded file", w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/attachments/999", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing attachment status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"web/ai-playground/migrations"
+	"web/ai-playground/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// copyBatchSize is how many rows are read and written at a time.
+const copyBatchSize = 500
+
+// copyDatabase copies the chats, messages and attachments of an SQLite
+// database into dst, keeping their IDs, timestamps and soft deletes. dst
+// must be migrated and hold no chats yet. The source file is only read. The
+// model list cache is not copied; it is fetched again when needed.
+func copyDatabase(dst *gorm.DB, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error opening source database: %v", err)
+	}
+	src, releaseSource, err := openSource(path)
+	if err != nil {
+		return err
+	}
+	defer releaseSource()
+
+	var existing int64
+	if err := dst.Unscoped().Model(&models.Chat{}).Count(&existing).Error; err != nil {
+		return fmt.Errorf("error checking target database: %v", err)
+	}
+	if existing > 0 {
+		return errors.New("target database already has chats")
+	}
+
+	return dst.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Session(&gorm.Session{SkipHooks: true})
+
+		// Chats and messages reference each other, so chats go in without
+		// their parent and fork message, which are set once messages exist
+		type chatLinks struct {
+			id            uint
+			parentID      *uint
+			forkMessageID *uint
+		}
+		var links []chatLinks
+		chats, err := copyTable(src, tx, func(chat *models.Chat) {
+			if chat.ParentID != nil || chat.ForkMessageID != nil {
+				links = append(links, chatLinks{chat.ID, chat.ParentID, chat.ForkMessageID})
+			}
+			chat.ParentID = nil
+			chat.ForkMessageID = nil
+		})
+		if err != nil {
+			return fmt.Errorf("error copying chats: %v", err)
+		}
+		messages, err := copyTable[models.Message](src, tx, nil)
+		if err != nil {
+			return fmt.Errorf("error copying messages: %v", err)
+		}
+		attachments, err := copyTable[models.Attachment](src, tx, nil)
+		if err != nil {
+			return fmt.Errorf("error copying attachments: %v", err)
+		}
+		for _, link := range links {
+			if err := tx.Model(&models.Chat{}).Where("id = ?", link.id).Updates(map[string]interface{}{
+				"parent_id":       link.parentID,
+				"fork_message_id": link.forkMessageID,
+			}).Error; err != nil {
+				return fmt.Errorf("error linking chat %d: %v", link.id, err)
+			}
+		}
+
+		// Rows were inserted with explicit IDs, which Postgres sequences do
+		// not notice; SQLite keeps track by itself
+		if tx.Dialector.Name() == "postgres" {
+			for _, table := range []string{"chats", "messages", "attachments"} {
+				if err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s", table, table)).Error; err != nil {
+					return fmt.Errorf("error resetting %s sequence: %v", table, err)
+				}
+			}
+		}
+
+		log.Printf("Copied %d chats, %d messages and %d attachments", chats, messages, attachments)
+		return nil
+	})
+}
+
+// openSource opens a snapshot of the SQLite database at path, brought up to
+// date with pending migrations so it can be read with the current models.
+// The file itself is opened read-only and left as it is. release removes the
+// snapshot.
+func openSource(path string) (src *gorm.DB, release func(), err error) {
+	original, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening source database: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "copy-db")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating source snapshot: %v", err)
+	}
+	release = func() {
+		if src != nil {
+			if sqlDB, err := src.DB(); err == nil {
+				sqlDB.Close()
+			}
+		}
+		os.RemoveAll(dir)
+	}
+	snapshot := filepath.Join(dir, "source.db")
+	err = original.Exec("VACUUM INTO ?", snapshot).Error
+	if sqlDB, dbErr := original.DB(); dbErr == nil {
+		sqlDB.Close()
+	}
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("error creating source snapshot: %v", err)
+	}
+
+	if src, err = gorm.Open(sqlite.Open(snapshot), &gorm.Config{}); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("error opening source snapshot: %v", err)
+	}
+	applied, err := migrations.New(src).Up(0)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("error migrating source snapshot: %w", err)
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %d to source snapshot: %s", m.Version, m.Description)
+	}
+	return src, release, nil
+}
+
+// copyTable copies every row of a model's table, deleted ones included,
+// calling prepare on each row before it is written.
+func copyTable[T any](src, dst *gorm.DB, prepare func(*T)) (int, error) {
+	var batch []T
+	copied := 0
+	err := src.Unscoped().FindInBatches(&batch, copyBatchSize, func(_ *gorm.DB, _ int) error {
+		if prepare != nil {
+			for i := range batch {
+				prepare(&batch[i])
+			}
+		}
+		if err := dst.Omit(clause.Associations).Create(&batch).Error; err != nil {
+			return err
+		}
+		copied += len(batch)
+		return nil
+	}).Error
+	return copied, err
+}
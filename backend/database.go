@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// databaseDialector picks the gorm driver for a DSN. Without a dialect, a
+// postgres:// URL selects Postgres and anything else is an SQLite file.
+func databaseDialector(dialect, dsn string) (gorm.Dialector, error) {
+	if dialect == "" {
+		dialect = "sqlite"
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			dialect = "postgres"
+		}
+	}
+	switch dialect {
+	case "sqlite":
+		return sqlite.Open(dsn), nil
+	case "postgres":
+		return postgres.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DIALECT: %q", dialect)
+	}
+}
+
+// openDatabase connects to the database named by DB_DIALECT and DB_DSN,
+// chat.db in the working directory by default.
+func openDatabase() (*gorm.DB, error) {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = "chat.db"
+	}
+	dialector, err := databaseDialector(os.Getenv("DB_DIALECT"), dsn)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(dialector, &gorm.Config{})
+}
@@ -1,34 +1,116 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"web/ai-playground/controllers"
-	"web/ai-playground/models"
+	"web/ai-playground/migrations"
 	"web/ai-playground/services"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
+	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Initialize SQLite database
-	db, err := gorm.Open(sqlite.Open("chat.db"), &gorm.Config{})
+	// A .env file is optional; plain environment variables work as well
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file loaded, using environment variables")
+	}
+
+	// SQLite by default, or the database configured by DB_DIALECT and DB_DSN
+	db, err := openDatabase()
 	if err != nil {
 		log.Fatal("failed to connect database:", err)
 	}
 
-	// Migrate the schema
-	err = db.AutoMigrate(&models.Chat{}, &models.Message{})
+	// "migrate" manages the schema by hand instead of starting the server
+	migrator := migrations.New(db)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(migrator, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Bring the schema up to date, refusing a database from a newer build
+	applied, err := migrator.Up(0)
 	if err != nil {
 		log.Fatal("failed to migrate database:", err)
 	}
+	for _, m := range applied {
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+	}
+	if unindexed, err := migrations.SearchUnindexed(db); err != nil {
+		log.Fatal("failed to inspect the search index:", err)
+	} else if unindexed {
+		log.Println("Warning: SQLite was built without FTS5, so search scans messages unranked; build with -tags sqlite_fts5")
+	}
+
+	// "copy-db" fills the freshly migrated database from an SQLite file
+	if len(os.Args) > 1 && os.Args[1] == "copy-db" {
+		if len(os.Args) != 3 {
+			log.Fatal("usage: copy-db <sqlite file>")
+		}
+		if err := copyDatabase(db, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	upstreamClient, err := services.NewUpstreamClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	services.DefaultUpstreamClient = upstreamClient
 
-	// Initialize services with db
-	openRouterService := services.NewOpenRouterService(db)
+	// Initialize services with db and the available providers
+	chatService := services.NewChatService(db,
+		services.NewOpenRouterProvider(os.Getenv("OPENROUTER_API_KEY")),
+		services.NewMockProviderFromEnv(),
+	)
+
+	// With a key, anthropic/* models go to Anthropic directly instead of OpenRouter
+	if apiKey := os.Getenv("ANTHROPIC_API_KEY"); apiKey != "" {
+		chatService.RegisterProvider(services.NewAnthropicProvider(apiKey, os.Getenv("ANTHROPIC_BASE_URL")))
+	}
+
+	if err := chatService.ConfigureFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if err := chatService.MarkInterruptedMessages(); err != nil {
+		log.Fatal(err)
+	}
+	go chatService.CleanUpAttachments(context.Background())
+
+	// Self-hosted OpenAI-compatible servers, addressed as "<name>/<model>"
+	endpoints, err := services.LoadEndpointsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, endpoint := range endpoints {
+		if _, exists := chatService.Providers[endpoint.Name]; exists {
+			log.Fatalf("provider endpoint %q conflicts with a built-in provider", endpoint.Name)
+		}
+		chatService.RegisterProvider(services.NewOpenAICompatibleProvider(endpoint))
+		log.Printf("Registered provider %s at %s", endpoint.Name, endpoint.BaseURL)
+	}
+
+	// Without an OpenRouter key fall back to the offline mock provider
+	chatService.DefaultProvider = os.Getenv("DEFAULT_PROVIDER")
+	if chatService.DefaultProvider == "" {
+		chatService.DefaultProvider = "openrouter"
+		if os.Getenv("OPENROUTER_API_KEY") == "" {
+			chatService.DefaultProvider = "mock"
+		}
+	}
+	if _, ok := chatService.Providers[chatService.DefaultProvider]; !ok {
+		log.Fatalf("unknown DEFAULT_PROVIDER: %q", chatService.DefaultProvider)
+	}
+	log.Printf("Default provider: %s", chatService.DefaultProvider)
 
 	// Initialize controllers
-	chatController := controllers.NewChatController(openRouterService)
+	chatController := controllers.NewChatController(chatService)
 
 	// Set up Gin router
 	router := gin.Default()
@@ -36,7 +118,7 @@ func main() {
 	// Configure CORS
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -57,15 +139,26 @@ func main() {
 func setupRoutes(r *gin.Engine, cc *controllers.ChatController) {
 	api := r.Group("/api")
 	{
+		api.GET("/models", cc.HandleGetModels)
+		api.GET("/tools", cc.HandleGetTools)
 		api.POST("/chat", cc.HandleChat)
 		api.GET("/chat", cc.HandleGetChats)
+		api.GET("/search", cc.HandleSearch)
 		api.POST("/chat/new", cc.HandleNewChat)
 		api.GET("/chat/:id", cc.HandleGetChat)
+		api.GET("/chat/:id/export", cc.HandleExportChat)
+		api.GET("/export", cc.HandleExportChats)
+		api.POST("/import", cc.HandleImport)
+		api.PATCH("/chat/:id", cc.HandleUpdateChat)
 		api.POST("/chat/:id/star", cc.HandleToggleChatStar)
 		api.POST("/message/:id/star", cc.HandleToggleMessageStar)
+		api.POST("/message/:id/stop", cc.HandleStopGeneration)
+		api.GET("/message/:id/stream", cc.HandleStreamMessage)
 		api.DELETE("/chat/:id", cc.HandleDeleteChat)
 		api.POST("/chat/fork", cc.HandleForkChat)
 		api.GET("/chat/:id/forks", cc.HandleGetChatForks)
 		api.GET("/chat/:id/fork-message/:messageId", cc.HandleGetParentForkMessage)
+		api.POST("/attachments", cc.HandleUploadAttachment)
+		api.GET("/attachments/:id", cc.HandleGetAttachment)
 	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"web/ai-playground/migrations"
+)
+
+const migrateUsage = `usage: migrate status
+       migrate up [version]   apply pending migrations, up to version if given
+       migrate down [steps]   roll back the last migration, or the last steps`
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(migrator *migrations.Migrator, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	n := 0
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid number %q\n%s", args[1], migrateUsage)
+		}
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt
+			}
+			description := s.Description
+			if s.Unknown {
+				description += " (unknown to this build)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, description)
+		}
+		return w.Flush()
+	case "up":
+		applied, err := migrator.Up(n)
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Description)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		rolledBack, err := migrator.Down(n)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back migration %d: %s\n", m.Version, m.Description)
+		}
+		return err
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,116 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// The schema as it was when versioned migrations replaced AutoMigrate,
+// frozen here so later changes to the models do not alter this step.
+// Databases created by AutoMigrate already have it, and only gain what
+// they lack.
+
+type chat0001 struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      string
+	UpdatedAt      string
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	ModelName      string
+	ProviderName   string
+	FallbackModels string
+	SystemPrompt   string
+	DefaultParams  string
+	Tools          string
+	Starred        bool `gorm:"default:false"`
+	ParentID       *uint
+	ForkMessageID  *uint
+	Messages       []message0001 `gorm:"foreignKey:ChatID"`
+	Forks          []chat0001    `gorm:"foreignKey:ParentID"`
+}
+
+func (chat0001) TableName() string { return "chats" }
+
+type message0001 struct {
+	ID               uint `gorm:"primarykey"`
+	CreatedAt        string
+	UpdatedAt        string
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+	ChatID           uint
+	Role             string
+	Content          string
+	Reasoning        string
+	ModelName        string
+	Starred          bool `gorm:"default:false"`
+	PromptTokens     int
+	CompletionTokens int
+	ReasoningTokens  int
+	TotalTokens      int
+	Status           string
+	FinishReason     string
+	Error            string
+	ToolCalls        string
+	ToolCallID       string
+	ToolName         string
+	CallerMessageID  *uint
+	Params           string
+	GenerationID     string
+	ProviderName     string
+	UpstreamModel    string
+	UpstreamProvider string
+	TimeToFirstToken int64
+	Duration         int64
+	TokensPerSecond  float64
+	ForkedChats      []chat0001       `gorm:"foreignKey:ForkMessageID"`
+	Attachments      []attachment0001 `gorm:"foreignKey:MessageID"`
+}
+
+func (message0001) TableName() string { return "messages" }
+
+type modelListCache0001 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt string
+	UpdatedAt string
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Provider  string         `gorm:"uniqueIndex"`
+	Models    string
+	FetchedAt string
+}
+
+func (modelListCache0001) TableName() string { return "model_list_caches" }
+
+type attachment0001 struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   string
+	UpdatedAt   string
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	MessageID   *uint          `gorm:"index"`
+	FileName    string
+	ContentType string
+	Size        int64
+	SHA256      string
+	StoragePath string
+}
+
+func (attachment0001) TableName() string { return "attachments" }
+
+func init() {
+	register(Migration{
+		Version:     1,
+		Description: "initial schema",
+		Up: func(tx *gorm.DB) error {
+			tables := []interface{}{&chat0001{}, &message0001{}, &modelListCache0001{}, &attachment0001{}}
+			if tx.Dialector.Name() != "sqlite" && !tx.Migrator().HasTable(&chat0001{}) {
+				// Chats and messages reference each other, and unlike SQLite
+				// other databases refuse a key to a table not created yet:
+				// create the tables first and add the keys below
+				tx.Config.DisableForeignKeyConstraintWhenMigrating = true
+				err := tx.AutoMigrate(tables...)
+				tx.Config.DisableForeignKeyConstraintWhenMigrating = false
+				if err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(tables...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&attachment0001{}, &modelListCache0001{}, &message0001{}, &chat0001{})
+		},
+	})
+}
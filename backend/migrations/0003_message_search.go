@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Full-text index over message content. SQLite keeps an external content
+// FTS5 table in step with messages through triggers when the driver was
+// built with -tags sqlite_fts5; without it no index is created and search
+// scans the messages instead, until a build with FTS5 runs Up. Postgres
+// indexes the tsvector of the content directly.
+
+// ErrNoFTS5 is returned on SQLite when the database has an FTS5 search index
+// and the driver was built without FTS5, so messages could not be written.
+var ErrNoFTS5 = errors.New("the database has an SQLite FTS5 search index, which this build cannot open; build with -tags sqlite_fts5")
+
+// hasFTS5 reports whether the SQLite driver was built with FTS5.
+func hasFTS5(tx *gorm.DB) (bool, error) {
+	var fts5 bool
+	err := tx.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error
+	return fts5, err
+}
+
+// SearchUnindexed reports whether the database is SQLite and the driver was
+// built without FTS5, so no search index can be created and search scans
+// messages instead.
+func SearchUnindexed(db *gorm.DB) (bool, error) {
+	if db.Dialector.Name() != "sqlite" {
+		return false, nil
+	}
+	fts5, err := hasFTS5(db)
+	return !fts5, err
+}
+
+// checkSearchIndex fails with ErrNoFTS5 when the database has an FTS5 index
+// this build cannot open. Every write to messages goes through its triggers,
+// so the mismatch would otherwise only surface when a message is saved.
+func checkSearchIndex(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	var definition string
+	if err := db.Raw("SELECT sql FROM sqlite_master WHERE name = 'messages_fts'").Scan(&definition).Error; err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToLower(definition), "fts5") {
+		return nil
+	}
+	fts5, err := hasFTS5(db)
+	if err != nil {
+		return err
+	}
+	if !fts5 {
+		return ErrNoFTS5
+	}
+	return nil
+}
+
+// searchIndexVersion is the migration that adds the search index.
+const searchIndexVersion = 3
+
+// ensureSearchIndex creates the SQLite FTS5 index and its triggers when the
+// driver has FTS5 and they are missing, indexing the messages already
+// written. A database migrated by a build without FTS5 has no index, so
+// this runs on every Up to add it once a build with FTS5 opens it.
+func ensureSearchIndex(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	fts5, err := hasFTS5(tx)
+	if err != nil || !fts5 {
+		// Search falls back to scanning messages
+		return err
+	}
+	var count int64
+	if err := tx.Raw("SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'").Scan(&count).Error; err != nil {
+		return err
+	}
+	statements := []string{
+		"CREATE TRIGGER IF NOT EXISTS messages_fts_after_insert AFTER INSERT ON messages BEGIN INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content); END",
+		"CREATE TRIGGER IF NOT EXISTS messages_fts_after_delete AFTER DELETE ON messages BEGIN INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content); END",
+		"CREATE TRIGGER IF NOT EXISTS messages_fts_after_update AFTER UPDATE OF content ON messages BEGIN INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content); INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content); END",
+	}
+	if count == 0 {
+		statements = append([]string{"CREATE VIRTUAL TABLE messages_fts USING fts5(content, content='messages', content_rowid='id')"}, statements...)
+		// Index the messages written before the index existed
+		statements = append(statements, "INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')")
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	register(Migration{
+		Version:     searchIndexVersion,
+		Description: "message search",
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "postgres" {
+				return tx.Exec("CREATE INDEX IF NOT EXISTS idx_messages_content_search ON messages USING GIN (to_tsvector('simple', content))").Error
+			}
+			return ensureSearchIndex(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "postgres" {
+				return tx.Exec("DROP INDEX IF EXISTS idx_messages_content_search").Error
+			}
+			for _, statement := range []string{
+				"DROP TRIGGER IF EXISTS messages_fts_after_insert",
+				"DROP TRIGGER IF EXISTS messages_fts_after_update",
+				"DROP TRIGGER IF EXISTS messages_fts_after_delete",
+				"DROP TABLE IF EXISTS messages_fts",
+			} {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
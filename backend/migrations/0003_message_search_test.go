@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"testing"
+)
+
+func TestUpCreatesMissingSearchIndex(t *testing.T) {
+	db := testDB(t)
+	fts5, err := hasFTS5(db)
+	if err != nil {
+		t.Fatalf("hasFTS5: %v", err)
+	}
+	if !fts5 {
+		t.Skip("the SQLite driver was built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	// A database migrated by a build without FTS5 has the migration recorded
+	// but no index
+	m := New(db)
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	for _, statement := range []string{
+		"DROP TRIGGER messages_fts_after_insert",
+		"DROP TRIGGER messages_fts_after_update",
+		"DROP TRIGGER messages_fts_after_delete",
+		"DROP TABLE messages_fts",
+		"INSERT INTO chats (created_at, updated_at) VALUES ('2026-01-01T00:00:00Z', '2026-01-01T00:00:00Z')",
+		"INSERT INTO messages (chat_id, role, content, created_at, updated_at) VALUES (1, 'user', 'written before the index', '2026-01-01T00:00:00Z', '2026-01-01T00:00:00Z')",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	applied, err := m.Up(0)
+	if err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second Up applied %d migrations, want none", len(applied))
+	}
+	var matches int64
+	if err := db.Raw("SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'index'").Scan(&matches).Error; err != nil {
+		t.Fatalf("searching the index: %v", err)
+	}
+	if matches != 1 {
+		t.Errorf("index holds %d matching messages, want the one written before it", matches)
+	}
+
+	// New messages are indexed by the recreated triggers
+	if err := db.Exec("INSERT INTO messages (chat_id, role, content, created_at, updated_at) VALUES (1, 'assistant', 'written after', '2026-01-01T00:00:00Z', '2026-01-01T00:00:00Z')").Error; err != nil {
+		t.Fatalf("inserting message: %v", err)
+	}
+	if err := db.Raw("SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'after'").Scan(&matches).Error; err != nil {
+		t.Fatalf("searching the index: %v", err)
+	}
+	if matches != 1 {
+		t.Errorf("index holds %d messages written after it, want 1", matches)
+	}
+}
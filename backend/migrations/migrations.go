@@ -0,0 +1,304 @@
+// Package migrations evolves the database schema through numbered steps,
+// recording the ones applied in the schema_migrations table. Steps are
+// written in Go, registered from an init function, or as SQL files in sql/
+// named "<version>_<description>.up.sql" with an optional ".down.sql".
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one step of the schema. Down may be nil for steps that
+// cannot be rolled back.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this build does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var registered []Migration
+
+// register adds a Go migration; called from init functions.
+func register(m Migration) {
+	registered = append(registered, m)
+}
+
+// All returns every known migration in version order.
+func All() []Migration {
+	all := append([]Migration(nil), registered...)
+	all = append(all, loadSQL()...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	for i := 1; i < len(all); i++ {
+		if all[i].Version == all[i-1].Version {
+			panic(fmt.Sprintf("duplicate migration version %d", all[i].Version))
+		}
+	}
+	return all
+}
+
+// loadSQL builds migrations from the embedded SQL files. Each file is run as
+// a whole, so it may hold several statements.
+func loadSQL() []Migration {
+	byVersion := make(map[int]*Migration)
+	var versions []int
+	entries, err := fs.ReadDir(sqlFiles, "sql")
+	if err != nil {
+		panic(fmt.Sprintf("error reading SQL migrations: %v", err))
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, description, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("invalid SQL migration name %q", name))
+		}
+		data, err := sqlFiles.ReadFile(path.Join("sql", name))
+		if err != nil {
+			panic(fmt.Sprintf("error reading SQL migration %s: %v", name, err))
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Description: strings.ReplaceAll(description, "_", " ")}
+			byVersion[version] = m
+			versions = append(versions, version)
+		}
+		step := execSQL(string(data))
+		if direction == "up" {
+			m.Up = step
+		} else {
+			m.Down = step
+		}
+	}
+
+	var migrations []Migration
+	for _, version := range versions {
+		if byVersion[version].Up == nil {
+			panic(fmt.Sprintf("SQL migration %d has no up file", version))
+		}
+		migrations = append(migrations, *byVersion[version])
+	}
+	return migrations
+}
+
+func execSQL(statements string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(statements).Error
+	}
+}
+
+// schemaMigration is a row of schema_migrations.
+type schemaMigration struct {
+	Version     int    `gorm:"primaryKey;autoIncrement:false"`
+	Description string `gorm:"not null"`
+	AppliedAt   string `gorm:"not null"` // RFC 3339
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies and rolls back migrations on a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func New(db *gorm.DB) *Migrator {
+	return &Migrator{db: db, migrations: All()}
+}
+
+// Latest is the version of the newest migration this build knows.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// applied returns the recorded migrations by version, creating the
+// schema_migrations table on first use.
+func (m *Migrator) applied() (map[int]schemaMigration, error) {
+	if err := m.db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("error loading applied migrations: %v", err)
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Current returns the highest applied version, 0 for an empty database.
+func (m *Migrator) Current() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// Check fails with ErrSchemaTooNew when the database was migrated by a
+// newer build, which this one must not run against, and with ErrNoFTS5 when
+// its search index needs SQLite FTS5 and this build lacks it.
+func (m *Migrator) Check() error {
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrSchemaTooNew, current, m.Latest())
+	}
+	return checkSearchIndex(m.db)
+}
+
+// Status describes a migration and whether it has been applied. Migrations
+// applied by a newer build are listed as unknown.
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   string
+	Unknown     bool
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, migration := range m.migrations {
+		row, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     ok,
+			AppliedAt:   row.AppliedAt,
+		})
+		delete(applied, migration.Version)
+	}
+	for _, row := range applied {
+		statuses = append(statuses, Status{Version: row.Version, Description: row.Description, Applied: true, AppliedAt: row.AppliedAt, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies pending migrations up to and including target, or all of them
+// when target is 0. Each runs in its own transaction together with its
+// schema_migrations row. Once the search index migration is applied, an
+// SQLite index missing because an earlier build lacked FTS5 is created. It
+// returns the migrations applied.
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now().Format(time.RFC3339),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error applying migration %d (%s): %v", migration.Version, migration.Description, err)
+		}
+		done = append(done, migration)
+	}
+
+	if _, ok := applied[searchIndexVersion]; ok {
+		if err := ensureSearchIndex(m.db); err != nil {
+			return done, fmt.Errorf("error creating search index: %v", err)
+		}
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of most recent migrations, newest first.
+// It returns the migrations rolled back.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return done, fmt.Errorf("migration %d (%s) cannot be rolled back", migration.Version, migration.Description)
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error rolling back migration %d (%s): %v", migration.Version, migration.Description, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
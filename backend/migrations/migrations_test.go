@@ -0,0 +1,145 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens an empty SQLite database in a temporary directory.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chat.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// tables lists the tables of an SQLite database, search index included.
+func tables(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+	var names []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'messages_fts_%' ORDER BY name").Scan(&names).Error; err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+	return names
+}
+
+// appliedVersions lists the versions Status reports as applied.
+func appliedVersions(t *testing.T, m *Migrator) []int {
+	t.Helper()
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	var versions []int
+	for _, status := range statuses {
+		if status.Applied {
+			versions = append(versions, status.Version)
+		}
+	}
+	return versions
+}
+
+func TestUpDownUp(t *testing.T) {
+	db := testDB(t)
+	m := New(db)
+	all := All()
+
+	applied, err := m.Up(0)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("Up applied %d migrations, want all %d", len(applied), len(all))
+	}
+	if current, err := m.Current(); err != nil || current != m.Latest() {
+		t.Errorf("Current = %d, %v, want %d", current, err, m.Latest())
+	}
+	if got := appliedVersions(t, m); len(got) != len(all) {
+		t.Errorf("Status lists %v applied, want all %d", got, len(all))
+	}
+	if err := m.Check(); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	migrated := fmt.Sprint(tables(t, db))
+
+	// One step down leaves the earlier ones in place
+	if _, err := m.Down(1); err != nil {
+		t.Fatalf("Down(1): %v", err)
+	}
+	if got := appliedVersions(t, m); len(got) != len(all)-1 || got[len(got)-1] == m.Latest() {
+		t.Errorf("Status lists %v applied after one step down, want all but %d", got, m.Latest())
+	}
+
+	rolledBack, err := m.Down(len(all))
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(rolledBack) != len(all)-1 {
+		t.Errorf("Down rolled back %d migrations, want the %d left", len(rolledBack), len(all)-1)
+	}
+	if got := appliedVersions(t, m); len(got) != 0 {
+		t.Errorf("Status lists %v applied after rolling back everything", got)
+	}
+	if got := tables(t, db); fmt.Sprint(got) != "[schema_migrations]" {
+		t.Errorf("tables after rolling back = %v, want only schema_migrations", got)
+	}
+
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if got := fmt.Sprint(tables(t, db)); got != migrated {
+		t.Errorf("tables after migrating again = %s, want %s", got, migrated)
+	}
+}
+
+func TestNewerSchemaRefused(t *testing.T) {
+	db := testDB(t)
+	m := New(db)
+	if _, err := m.Up(0); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	newer := schemaMigration{Version: m.Latest() + 1, Description: "from a newer build", AppliedAt: "2026-01-01T00:00:00Z"}
+	if err := db.Create(&newer).Error; err != nil {
+		t.Fatalf("recording newer migration: %v", err)
+	}
+
+	if err := m.Check(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Check = %v, want ErrSchemaTooNew", err)
+	}
+	if _, err := m.Up(0); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Up = %v, want ErrSchemaTooNew", err)
+	}
+	if _, err := m.Down(1); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Down = %v, want ErrSchemaTooNew", err)
+	}
+	if current, err := m.Current(); err != nil || current != newer.Version {
+		t.Errorf("Current = %d, %v, want %d", current, err, newer.Version)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.Version != newer.Version || !last.Applied || !last.Unknown || last.Description != newer.Description {
+		t.Errorf("last status = %+v, want version %d applied and unknown", last, newer.Version)
+	}
+	for _, status := range statuses[:len(statuses)-1] {
+		if !status.Applied || status.Unknown {
+			t.Errorf("status = %+v, want applied and known", status)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+-- Every chat view and history rebuild selects a chat's messages in order
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id_created_at ON messages (chat_id, created_at);
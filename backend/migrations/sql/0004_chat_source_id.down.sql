@@ -0,0 +1,3 @@
+-- DROP COLUMN needs SQLite 3.35 or later; the bundled driver ships 3.45
+DROP INDEX IF EXISTS idx_chats_source_id;
+ALTER TABLE chats DROP COLUMN source_id;
@@ -0,0 +1,3 @@
+-- Imported chats remember where they came from, so importing again skips them
+ALTER TABLE chats ADD COLUMN source_id text;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chats_source_id ON chats (source_id);
@@ -0,0 +1,2 @@
+-- DROP COLUMN needs SQLite 3.35 or later; the bundled driver ships 3.45
+ALTER TABLE chats DROP COLUMN title;
@@ -0,0 +1,2 @@
+-- Imported chats keep the title their source gave them
+ALTER TABLE chats ADD COLUMN title text NOT NULL DEFAULT '';
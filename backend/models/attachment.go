@@ -0,0 +1,32 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Attachment is a file uploaded to be sent with a message. It is unlinked
+// until a message is sent with it, after which MessageID points to that
+// message. The file itself is stored on disk.
+type Attachment struct {
+	BaseModel
+	MessageID   *uint  `json:"messageId" gorm:"index"`
+	FileName    string `json:"fileName"`    // Name of the uploaded file
+	ContentType string `json:"contentType"` // Detected from the content, e.g. "image/png"
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	StoragePath string `json:"-"`            // Relative to the attachments directory
+	URL         string `json:"url" gorm:"-"` // Download URL, filled in when loaded
+}
+
+// AfterFind fills in the download URL.
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	return nil
+}
+
+// AfterCreate fills in the download URL of a new attachment.
+func (a *Attachment) AfterCreate(tx *gorm.DB) error {
+	return a.AfterFind(tx)
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -30,26 +31,129 @@ func (bm *BaseModel) BeforeUpdate(tx *gorm.DB) error {
 
 type Chat struct {
 	BaseModel
-	Messages      []Message `json:"messages"`
-	ModelName     string    `json:"modelName"`
-	Starred       bool      `json:"starred" gorm:"default:false"`
-	ParentID      *uint     `json:"parentId"`                                    // ID of the parent chat this was forked from
-	ForkMessageID *uint     `json:"forkMessageId"`                               // ID of the message where the fork occurred
-	Parent        *Chat     `json:"parent" gorm:"foreignKey:ParentID"`           // Parent chat reference
-	Forks         []Chat    `json:"forks" gorm:"foreignKey:ParentID"`            // Child chat references
-	ForkMessage   *Message  `json:"forkMessage" gorm:"foreignKey:ForkMessageID"` // Reference to forked message
+	Messages       []Message         `json:"messages"`
+	ModelName      string            `json:"modelName"`
+	Title          string            `json:"title"`                                 // Set on imported chats, empty otherwise
+	ProviderName   string            `json:"providerName"`                          // Provider serving this chat, empty for the default
+	FallbackModels []string          `json:"fallbackModels" gorm:"serializer:json"` // Models tried in order when ModelName fails
+	SystemPrompt   string            `json:"systemPrompt"`                          // Sent ahead of the history with every request
+	DefaultParams  *GenerationParams `json:"defaultParams" gorm:"serializer:json"`  // Used for parameters a request leaves unset
+	Tools          []string          `json:"tools" gorm:"serializer:json"`          // Names of the server tools the model may call
+	Starred        bool              `json:"starred" gorm:"default:false"`
+	ParentID       *uint             `json:"parentId"`                                    // ID of the parent chat this was forked from
+	ForkMessageID  *uint             `json:"forkMessageId"`                               // ID of the message where the fork occurred
+	SourceID       *string           `json:"sourceId" gorm:"uniqueIndex"`                 // Where an imported chat came from, e.g. "chatgpt:<id>"
+	Parent         *Chat             `json:"parent" gorm:"foreignKey:ParentID"`           // Parent chat reference
+	Forks          []Chat            `json:"forks" gorm:"foreignKey:ParentID"`            // Child chat references
+	ForkMessage    *Message          `json:"forkMessage" gorm:"foreignKey:ForkMessageID"` // Reference to forked message
+}
+
+// Message statuses
+const (
+	MessageStatusStreaming = "streaming" // Assistant content is still being generated
+	MessageStatusComplete  = "complete"
+	MessageStatusError     = "error"     // Generation failed or was interrupted by a crash
+	MessageStatusCancelled = "cancelled" // Stopped, or the client went away, before generation finished
+)
+
+// GenerationParams are the sampling settings sent upstream with a request,
+// using the OpenAI field names. Unset fields keep the provider's defaults.
+type GenerationParams struct {
+	Temperature      *float64         `json:"temperature,omitempty"`
+	TopP             *float64         `json:"top_p,omitempty"`
+	MaxTokens        *int             `json:"max_tokens,omitempty"`
+	Stop             []string         `json:"stop,omitempty"`
+	Seed             *int             `json:"seed,omitempty"`
+	FrequencyPenalty *float64         `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64         `json:"presence_penalty,omitempty"`
+	Provider         json.RawMessage  `json:"provider,omitempty"` // OpenRouter provider routing preferences
+	Reasoning        *ReasoningParams `json:"reasoning,omitempty"`
+}
+
+// ReasoningParams ask a reasoning model to think before answering, in
+// OpenRouter's format. Effort is "low", "medium" or "high"; MaxTokens sets
+// the thinking budget directly and wins over Effort.
+type ReasoningParams struct {
+	Effort    string `json:"effort,omitempty"`
+	MaxTokens *int   `json:"max_tokens,omitempty"`
+	Exclude   bool   `json:"exclude,omitempty"` // Think without returning the reasoning
+}
+
+// WithDefaults returns p with every unset field taken from defaults.
+func (p GenerationParams) WithDefaults(defaults *GenerationParams) GenerationParams {
+	if defaults == nil {
+		return p
+	}
+	if p.Temperature == nil {
+		p.Temperature = defaults.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = defaults.TopP
+	}
+	if p.MaxTokens == nil {
+		p.MaxTokens = defaults.MaxTokens
+	}
+	if p.Stop == nil {
+		p.Stop = defaults.Stop
+	}
+	if p.Seed == nil {
+		p.Seed = defaults.Seed
+	}
+	if p.FrequencyPenalty == nil {
+		p.FrequencyPenalty = defaults.FrequencyPenalty
+	}
+	if p.PresencePenalty == nil {
+		p.PresencePenalty = defaults.PresencePenalty
+	}
+	if p.Provider == nil {
+		p.Provider = defaults.Provider
+	}
+	if p.Reasoning == nil {
+		p.Reasoning = defaults.Reasoning
+	}
+	return p
+}
+
+// ToolCall is a function call requested by the model, in OpenAI's format.
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON-encoded arguments
 }
 
 type Message struct {
 	BaseModel
-	ChatID           uint   `json:"chatId"`
-	Chat             *Chat  `json:"chat" gorm:"foreignKey:ChatID"`
-	Role             string `json:"role"`
-	Content          string `json:"content"`
-	ModelName        string `json:"modelName"`
-	Starred          bool   `json:"starred" gorm:"default:false"`
-	PromptTokens     int    `json:"promptTokens"`
-	CompletionTokens int    `json:"completionTokens"`
-	TotalTokens      int    `json:"totalTokens"`
-	ForkedChats      []Chat `json:"forkedChats" gorm:"foreignKey:ForkMessageID"` // Chats forked from this message
+	ChatID           uint              `json:"chatId"`
+	Chat             *Chat             `json:"chat" gorm:"foreignKey:ChatID"`
+	Role             string            `json:"role"`
+	Content          string            `json:"content"`
+	Reasoning        string            `json:"reasoning"` // Thinking of reasoning models, kept apart from Content
+	ModelName        string            `json:"modelName"`
+	Starred          bool              `json:"starred" gorm:"default:false"`
+	PromptTokens     int               `json:"promptTokens"`
+	CompletionTokens int               `json:"completionTokens"`
+	ReasoningTokens  int               `json:"reasoningTokens"` // Part of CompletionTokens spent reasoning
+	TotalTokens      int               `json:"totalTokens"`
+	Status           string            `json:"status"`
+	FinishReason     string            `json:"finishReason"`                     // Upstream finish reason: stop, length, content_filter or tool_calls
+	Error            string            `json:"error"`                            // Why generation failed, when Status is error
+	ToolCalls        []ToolCall        `json:"toolCalls" gorm:"serializer:json"` // Tools the assistant asked to run
+	ToolCallID       string            `json:"toolCallId"`                       // Call a tool message answers
+	ToolName         string            `json:"toolName"`
+	CallerMessageID  *uint             `json:"callerMessageId"`                             // Assistant message whose tool call a tool message answers
+	Params           *GenerationParams `json:"params" gorm:"serializer:json"`               // Parameters the assistant answer was generated with
+	GenerationID     string            `json:"generationId"`                                // ID the upstream assigned to the answer
+	ProviderName     string            `json:"providerName"`                                // Provider that served the answer
+	UpstreamModel    string            `json:"upstreamModel"`                               // Model the upstream reports answering with
+	UpstreamProvider string            `json:"upstreamProvider"`                            // Backend OpenRouter routed to, e.g. "OpenAI"
+	TimeToFirstToken int64             `json:"timeToFirstTokenMs"`                          // Milliseconds until the first content, the whole duration when not streamed
+	Duration         int64             `json:"durationMs"`                                  // Milliseconds from request to last chunk
+	TokensPerSecond  float64           `json:"tokensPerSecond"`                             // Completion tokens per second of generation
+	ForkedChats      []Chat            `json:"forkedChats" gorm:"foreignKey:ForkMessageID"` // Chats forked from this message
+	Attachments      []Attachment      `json:"attachments" gorm:"foreignKey:MessageID"`
 }
@@ -0,0 +1,11 @@
+package models
+
+// ModelListCache keeps the last model list fetched from a provider, so the
+// catalog can be served without calling the provider on every request and
+// while it is unreachable.
+type ModelListCache struct {
+	BaseModel
+	Provider  string `json:"provider" gorm:"uniqueIndex"`
+	Models    string `json:"models"`    // JSON array of the provider's models
+	FetchedAt string `json:"fetchedAt"` // When Models was fetched, RFC 3339
+}
@@ -0,0 +1,663 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"web/ai-playground/models"
+)
+
+const anthropicVersion = "2023-06-01"
+
+// anthropicDefaultMaxTokens is sent when the request does not set a limit;
+// the Messages API requires one.
+const anthropicDefaultMaxTokens = 4096
+
+// anthropicModelAliases maps OpenRouter-style model IDs to the IDs accepted
+// by the Anthropic API. Unknown IDs are passed through unchanged.
+var anthropicModelAliases = map[string]string{
+	"claude-3-opus":     "claude-3-opus-latest",
+	"claude-3.5-haiku":  "claude-3-5-haiku-latest",
+	"claude-3.5-sonnet": "claude-3-5-sonnet-latest",
+	"claude-3.7-sonnet": "claude-3-7-sonnet-latest",
+}
+
+// AnthropicProvider talks to the Anthropic Messages API directly. Models are
+// addressed as "anthropic/<model>", matching OpenRouter's naming.
+type AnthropicProvider struct {
+	APIKey  string
+	BaseURL string
+	HTTP    *UpstreamClient // Nil to use DefaultUpstreamClient
+}
+
+func NewAnthropicProvider(apiKey, baseURL string) *AnthropicProvider {
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	return &AnthropicProvider{
+		APIKey:  apiKey,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+// anthropicContentBlock is one part of a message: text, an image or
+// document, a tool_use block calling a tool, a tool_result block answering
+// one or a thinking block.
+type anthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Signature string           `json:"signature,omitempty"`
+	Data      string           `json:"data,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// anthropicSourceFromDataURL decodes a base64 data URL into an image or
+// document source.
+func anthropicSourceFromDataURL(dataURL string) (*anthropicSource, bool) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	mediaType, encoding, _ := strings.Cut(header, ";")
+	if !ok || encoding != "base64" {
+		return nil, false
+	}
+	return &anthropicSource{Type: "base64", MediaType: mediaType, Data: data}, true
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+}
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        string               `json:"system,omitempty"`
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Stream        bool                 `json:"stream"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Thinking      *anthropicThinking   `json:"thinking,omitempty"`
+	Tools         []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// anthropicThinkingBudgets translate a reasoning effort into a thinking
+// budget.
+var anthropicThinkingBudgets = map[string]int{
+	"low":    anthropicMinThinkingBudget,
+	"medium": 4096,
+	"high":   16384,
+}
+
+// anthropicMinThinkingBudget is the smallest thinking budget the API accepts.
+const anthropicMinThinkingBudget = 1024
+
+// anthropicThinkingBudget returns the thinking budget for reasoning params,
+// reporting false when they do not ask for thinking: excluding reasoning
+// alone only hides it. An explicit budget wins over the effort, and
+// ValidateParams refuses one below the API's minimum; an unknown effort
+// thinks at medium.
+func anthropicThinkingBudget(r *models.ReasoningParams) (int, bool) {
+	if r == nil || (r.Effort == "" && r.MaxTokens == nil) {
+		return 0, false
+	}
+	budget := anthropicThinkingBudgets[r.Effort]
+	if r.MaxTokens != nil {
+		budget = *r.MaxTokens
+	} else if budget == 0 {
+		budget = anthropicThinkingBudgets["medium"]
+	}
+	return budget, true
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// anthropicEvent covers the fields of every stream event type we handle.
+type anthropicEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Data string `json:"data"`
+	} `json:"content_block"`
+	Message struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *AnthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (p *AnthropicProvider) upstreamModel(model string) string {
+	model = strings.TrimPrefix(model, "anthropic/")
+	if alias, ok := anthropicModelAliases[model]; ok {
+		return alias
+	}
+	return model
+}
+
+// ValidateParams refuses parameters the request would have to change: seed,
+// penalties and routing preferences, which the API has no equivalent for;
+// temperature and top_p while thinking, which it does not allow; a thinking
+// budget below the API's minimum; and a max_tokens at or below the thinking
+// budget, since thinking counts towards max_tokens and the API requires room
+// beyond it.
+func (p *AnthropicProvider) ValidateParams(params models.GenerationParams) error {
+	var unsupported []string
+	if params.Seed != nil {
+		unsupported = append(unsupported, "seed")
+	}
+	if params.FrequencyPenalty != nil {
+		unsupported = append(unsupported, "frequency_penalty")
+	}
+	if params.PresencePenalty != nil {
+		unsupported = append(unsupported, "presence_penalty")
+	}
+	if len(params.Provider) > 0 {
+		unsupported = append(unsupported, "provider")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%w: Anthropic does not support %s", ErrInvalidParams, strings.Join(unsupported, ", "))
+	}
+
+	budget, ok := anthropicThinkingBudget(params.Reasoning)
+	if !ok {
+		return nil
+	}
+	if budget < anthropicMinThinkingBudget {
+		return fmt.Errorf("%w: reasoning max_tokens (%d) is below the smallest Anthropic thinking budget (%d)", ErrInvalidParams, budget, anthropicMinThinkingBudget)
+	}
+	if params.Temperature != nil || params.TopP != nil {
+		return fmt.Errorf("%w: Anthropic does not allow temperature or top_p with reasoning", ErrInvalidParams)
+	}
+	if params.MaxTokens != nil && *params.MaxTokens <= budget {
+		return fmt.Errorf("%w: max_tokens (%d) must be above the Anthropic thinking budget (%d), which counts towards it", ErrInvalidParams, *params.MaxTokens, budget)
+	}
+	return nil
+}
+
+// toAnthropicRequest lifts system messages into the top-level system prompt
+// and merges consecutive messages of the same role, which the Messages API
+// rejects. Tool calls become tool_use blocks, tool messages become
+// tool_result blocks of a user message and attachments become image and
+// document blocks. Parameters the API has no equivalent for (seed, penalties and
+// routing preferences) are dropped, and so are temperature and top_p when
+// thinking, which the API does not allow; ChatService refuses such requests
+// through ValidateParams before they get here. Earlier reasoning is dropped too,
+// apart from the signed thinking blocks of turns that called tools, which
+// must precede their tool_use blocks.
+func (p *AnthropicProvider) toAnthropicRequest(req CompletionRequest) anthropicRequest {
+	apiReq := anthropicRequest{
+		Model:         p.upstreamModel(req.Model),
+		MaxTokens:     anthropicDefaultMaxTokens,
+		Stream:        req.Stream,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+	}
+	if req.MaxTokens != nil {
+		apiReq.MaxTokens = *req.MaxTokens
+	}
+	if budget, ok := anthropicThinkingBudget(req.Reasoning); ok {
+		apiReq.Temperature = nil
+		apiReq.TopP = nil
+		apiReq.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		// The budget is part of max_tokens, so leave room for the answer
+		// when the request left the limit to us. ValidateParams refuses an
+		// explicit limit that leaves none.
+		if req.MaxTokens == nil && apiReq.MaxTokens <= budget {
+			apiReq.MaxTokens = budget + anthropicDefaultMaxTokens
+		}
+	}
+
+	for _, tool := range req.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		apiReq.Tools = append(apiReq.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	if req.ToolChoice == "none" && len(apiReq.Tools) > 0 {
+		apiReq.ToolChoice = &anthropicToolChoice{Type: "none"}
+	}
+
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		role, blocks := anthropicBlocks(msg)
+		if len(blocks) == 0 {
+			continue
+		}
+		last := len(apiReq.Messages) - 1
+		if last >= 0 && apiReq.Messages[last].Role == role {
+			apiReq.Messages[last].Content = append(apiReq.Messages[last].Content, blocks...)
+			continue
+		}
+		apiReq.Messages = append(apiReq.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	apiReq.System = strings.Join(system, "\n\n")
+	return apiReq
+}
+
+// anthropicBlocks converts a message into the role and content blocks the
+// Messages API expects.
+func anthropicBlocks(msg ChatMessage) (string, []anthropicContentBlock) {
+	if msg.Role == "tool" {
+		return "user", []anthropicContentBlock{{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}}
+	}
+
+	var blocks []anthropicContentBlock
+	for _, block := range msg.Thinking {
+		if block.Redacted != "" {
+			blocks = append(blocks, anthropicContentBlock{Type: "redacted_thinking", Data: block.Redacted})
+		} else {
+			blocks = append(blocks, anthropicContentBlock{Type: "thinking", Thinking: block.Thinking, Signature: block.Signature})
+		}
+	}
+	if msg.Content != "" {
+		blocks = append(blocks, anthropicContentBlock{Type: "text", Text: msg.Content})
+	}
+	for _, part := range msg.Parts {
+		switch {
+		case part.Type == "text":
+			blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+		case part.ImageURL != nil:
+			if source, ok := anthropicSourceFromDataURL(part.ImageURL.URL); ok {
+				blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+			}
+		case part.File != nil:
+			if source, ok := anthropicSourceFromDataURL(part.File.FileData); ok {
+				blocks = append(blocks, anthropicContentBlock{Type: "document", Source: source})
+			}
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		input := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+	}
+	return msg.Role, blocks
+}
+
+func (p *AnthropicProvider) newRequest(method, path string, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequest(method, p.BaseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	return httpReq, nil
+}
+
+// postMessages sends the request to the Messages API and checks the
+// response status. The caller must close the returned body.
+func (p *AnthropicProvider) postMessages(ctx context.Context, req CompletionRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(p.toAnthropicRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	httpReq, err := p.newRequest("POST", "/v1/messages", jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams may pause between events; other answers arrive all at once
+	send := upstreamClient(p.HTTP).Do
+	if req.Stream {
+		send = upstreamClient(p.HTTP).Stream
+	}
+	resp, err := send(ctx, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errorResp anthropicEvent
+		if err := json.NewDecoder(resp.Body).Decode(&errorResp); err != nil || errorResp.Error == nil {
+			return nil, newUpstreamError(resp, "")
+		}
+		return nil, newUpstreamError(resp, errorResp.Error.Message)
+	}
+	return resp, nil
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req CompletionRequest) (*ChatResponse, error) {
+	req.Stream = false
+	resp, err := p.postMessages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var msg struct {
+		ID      string `json:"id"`
+		Model   string `json:"model"`
+		Content []struct {
+			Type      string          `json:"type"`
+			Text      string          `json:"text"`
+			Thinking  string          `json:"thinking"`
+			Signature string          `json:"signature"`
+			Data      string          `json:"data"`
+			ID        string          `json:"id"`
+			Name      string          `json:"name"`
+			Input     json.RawMessage `json:"input"`
+		} `json:"content"`
+		StopReason string         `json:"stop_reason"`
+		Usage      anthropicUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	var text, thinking strings.Builder
+	var toolCalls []models.ToolCall
+	var thinkingBlocks []ThinkingBlock
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			thinking.WriteString(block.Thinking)
+			thinkingBlocks = append(thinkingBlocks, ThinkingBlock{Thinking: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			thinkingBlocks = append(thinkingBlocks, ThinkingBlock{Redacted: block.Data})
+		case "tool_use":
+			toolCalls = append(toolCalls, models.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: models.ToolCallFunction{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	if req.Reasoning != nil && req.Reasoning.Exclude {
+		thinking.Reset()
+	}
+	promptTokens := msg.Usage.InputTokens + msg.Usage.CacheCreationInputTokens + msg.Usage.CacheReadInputTokens
+	return &ChatResponse{
+		ID:    msg.ID,
+		Model: msg.Model,
+		Choices: []Choice{{
+			Message:      ChatMessage{Role: "assistant", Content: text.String(), Reasoning: thinking.String(), ToolCalls: toolCalls, Thinking: thinkingBlocks},
+			FinishReason: anthropicFinishReason(msg.StopReason),
+		}},
+		Usage: &UsageData{
+			PromptTokens:     promptTokens,
+			CompletionTokens: msg.Usage.OutputTokens,
+			TotalTokens:      promptTokens + msg.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (p *AnthropicProvider) StreamChat(ctx context.Context, req CompletionRequest, onChunk func(StreamResponse) error) (*UsageData, error) {
+	req.Stream = true
+	resp, err := p.postMessages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Translate Anthropic events into OpenAI-style chunks. Tool calls are
+	// numbered in order of appearance, apart from the other content blocks.
+	// Thinking blocks are collected with their signature and passed on
+	// whole once they end.
+	var id, model string
+	var usage UsageData
+	toolIndexes := make(map[int]int)
+	thinkingBlocks := make(map[int]*ThinkingBlock)
+	err = readSSEEvents(resp.Body, func(name string, data []byte) error {
+		var event anthropicEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("Skipping Anthropic %q event that is not valid JSON: %v: %.200s", name, err, data)
+			return nil
+		}
+
+		switch event.Type {
+		case "message_start":
+			id = event.Message.ID
+			model = event.Message.Model
+			u := event.Message.Usage
+			usage.PromptTokens = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+			usage.CompletionTokens = u.OutputTokens
+		case "content_block_start":
+			switch event.ContentBlock.Type {
+			case "thinking":
+				thinkingBlocks[event.Index] = &ThinkingBlock{}
+				return nil
+			case "redacted_thinking":
+				thinkingBlocks[event.Index] = &ThinkingBlock{Redacted: event.ContentBlock.Data}
+				return nil
+			}
+			if event.ContentBlock.Type != "tool_use" {
+				return nil
+			}
+			index := len(toolIndexes)
+			toolIndexes[event.Index] = index
+			call := ToolCallDelta{
+				Index:    index,
+				ID:       event.ContentBlock.ID,
+				Type:     "function",
+				Function: models.ToolCallFunction{Name: event.ContentBlock.Name},
+			}
+			return onChunk(StreamResponse{
+				ID:      id,
+				Model:   model,
+				Choices: []StreamChoice{{Delta: StreamDelta{Role: "assistant", ToolCalls: []ToolCallDelta{call}}}},
+			})
+		case "content_block_delta":
+			var delta StreamDelta
+			switch event.Delta.Type {
+			case "input_json_delta":
+				index, ok := toolIndexes[event.Index]
+				if !ok || event.Delta.PartialJSON == "" {
+					return nil
+				}
+				call := ToolCallDelta{Index: index, Function: models.ToolCallFunction{Arguments: event.Delta.PartialJSON}}
+				delta = StreamDelta{Role: "assistant", ToolCalls: []ToolCallDelta{call}}
+			case "text_delta":
+				delta = StreamDelta{Role: "assistant", Content: event.Delta.Text}
+			case "thinking_delta":
+				if block, ok := thinkingBlocks[event.Index]; ok {
+					block.Thinking += event.Delta.Thinking
+				}
+				if req.Reasoning != nil && req.Reasoning.Exclude {
+					return nil
+				}
+				delta = StreamDelta{Role: "assistant", Reasoning: event.Delta.Thinking}
+			case "signature_delta":
+				if block, ok := thinkingBlocks[event.Index]; ok {
+					block.Signature += event.Delta.Signature
+				}
+				return nil
+			default:
+				return nil
+			}
+			return onChunk(StreamResponse{
+				ID:      id,
+				Model:   model,
+				Choices: []StreamChoice{{Delta: delta}},
+			})
+		case "content_block_stop":
+			block, ok := thinkingBlocks[event.Index]
+			if !ok {
+				return nil
+			}
+			delete(thinkingBlocks, event.Index)
+			return onChunk(StreamResponse{
+				ID:      id,
+				Model:   model,
+				Choices: []StreamChoice{{Delta: StreamDelta{Role: "assistant", Thinking: []ThinkingBlock{*block}}}},
+			})
+		case "message_delta":
+			// Usage on message_delta is cumulative
+			if event.Usage != nil {
+				usage.CompletionTokens = event.Usage.OutputTokens
+			}
+			if event.Delta.StopReason != "" {
+				return onChunk(StreamResponse{
+					ID:    id,
+					Model: model,
+					Choices: []StreamChoice{{
+						FinishReason: anthropicFinishReason(event.Delta.StopReason),
+					}},
+				})
+			}
+		case "message_stop":
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			final := usage
+			return onChunk(StreamResponse{ID: id, Model: model, Choices: []StreamChoice{}, Usage: &final})
+		case "error":
+			// The response already started with a 200, so take the status
+			// the error type stands for, letting overloads be retried
+			if event.Error != nil {
+				return &UpstreamError{StatusCode: anthropicErrorStatus(event.Error.Type), Message: event.Error.Message}
+			}
+			return &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "unknown stream error"}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return &usage, nil
+}
+
+// anthropicErrorStatuses are the HTTP statuses the Messages API answers
+// with for each error type.
+var anthropicErrorStatuses = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+// anthropicErrorStatus returns the status for an error type reported inside
+// a stream, treating unknown types as a server error.
+func anthropicErrorStatus(errorType string) int {
+	if status, ok := anthropicErrorStatuses[errorType]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// anthropicFinishReason maps Anthropic stop reasons onto OpenAI finish reasons.
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return stopReason
+	}
+}
+
+func (p *AnthropicProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := p.newRequest("GET", "/v1/models?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := upstreamClient(p.HTTP).Do(ctx, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching models: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response with status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error decoding models: %v", err)
+	}
+
+	infos := make([]ModelInfo, 0, len(list.Data))
+	for _, m := range list.Data {
+		infos = append(infos, ModelInfo{
+			ID:           "anthropic/" + m.ID,
+			Name:         "Anthropic: " + m.DisplayName,
+			Architecture: ModelArchitecture{Modality: "text+image->text"},
+			Provider:     p.Name(),
+		})
+	}
+	return infos, nil
+}
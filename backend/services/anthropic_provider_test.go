@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+func testAnthropicProvider(baseURL string) *AnthropicProvider {
+	p := NewAnthropicProvider("test-key", baseURL)
+	p.HTTP = NewUpstreamClient(time.Second, time.Second, time.Second)
+	return p
+}
+
+// anthropicEvents formats events as the frames of an Anthropic stream.
+func anthropicEvents(events ...string) []string {
+	frames := make([]string, len(events))
+	for i, data := range events {
+		var event struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(data), &event)
+		frames[i] = fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, data)
+	}
+	return frames
+}
+
+func TestAnthropicStreamChat(t *testing.T) {
+	server := sseServer(t, anthropicEvents(
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-test","usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me "}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"check."}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Checking"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":" now."}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_time"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"zone\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"UTC\"}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":42}}`,
+		`{"type":"message_stop"}`,
+	)...)
+
+	var content, reasoning, finishReason, id, model string
+	var thinking []ThinkingBlock
+	calls := map[int]*models.ToolCall{}
+	var finalUsage *UsageData
+	usage, err := testAnthropicProvider(server.URL).StreamChat(context.Background(), CompletionRequest{Model: "anthropic/claude-test"}, func(chunk StreamResponse) error {
+		id, model = chunk.ID, chunk.Model
+		if chunk.Usage != nil {
+			finalUsage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			reasoning += choice.Delta.Reasoning
+			thinking = append(thinking, choice.Delta.Thinking...)
+			for _, delta := range choice.Delta.ToolCalls {
+				call, ok := calls[delta.Index]
+				if !ok {
+					call = &models.ToolCall{}
+					calls[delta.Index] = call
+				}
+				if delta.ID != "" {
+					call.ID, call.Type, call.Function.Name = delta.ID, delta.Type, delta.Function.Name
+				}
+				call.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+
+	if id != "msg_1" || model != "claude-test" {
+		t.Errorf("id, model = %q, %q, want msg_1, claude-test", id, model)
+	}
+	if content != "Checking now." {
+		t.Errorf("content = %q", content)
+	}
+	if reasoning != "Let me check." {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if len(thinking) != 1 || thinking[0] != (ThinkingBlock{Thinking: "Let me check.", Signature: "sig"}) {
+		t.Errorf("thinking blocks = %+v, want one signed block", thinking)
+	}
+	call, ok := calls[0]
+	if len(calls) != 1 || !ok {
+		t.Fatalf("tool calls = %v, want one at index 0", calls)
+	}
+	if call.ID != "toolu_1" || call.Type != "function" || call.Function.Name != "get_time" || call.Function.Arguments != `{"zone":"UTC"}` {
+		t.Errorf("tool call = %+v", *call)
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", finishReason)
+	}
+	want := UsageData{PromptTokens: 15, CompletionTokens: 42, TotalTokens: 57}
+	if usage == nil || *usage != want {
+		t.Errorf("usage = %+v, want %+v", usage, want)
+	}
+	if finalUsage == nil || *finalUsage != want {
+		t.Errorf("usage chunk = %+v, want %+v", finalUsage, want)
+	}
+}
+
+func TestAnthropicStreamChatExcludedReasoning(t *testing.T) {
+	server := sseServer(t, anthropicEvents(
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-test","usage":{}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hidden"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_stop"}`,
+	)...)
+
+	var reasoning string
+	var thinking []ThinkingBlock
+	req := CompletionRequest{Model: "anthropic/claude-test"}
+	req.Reasoning = &models.ReasoningParams{Exclude: true}
+	_, err := testAnthropicProvider(server.URL).StreamChat(context.Background(), req, func(chunk StreamResponse) error {
+		for _, choice := range chunk.Choices {
+			reasoning += choice.Delta.Reasoning
+			thinking = append(thinking, choice.Delta.Thinking...)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	if reasoning != "" {
+		t.Errorf("reasoning = %q, want it excluded", reasoning)
+	}
+	// The signed block is still needed for tool loops
+	if len(thinking) != 1 || thinking[0].Thinking != "hidden" {
+		t.Errorf("thinking blocks = %+v", thinking)
+	}
+}
+
+func TestAnthropicStreamChatError(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     string
+		status    int
+		retryable bool
+	}{
+		{"overloaded", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, 529, true},
+		{"rate limited", `{"type":"error","error":{"type":"rate_limit_error","message":"Slow down"}}`, 429, true},
+		{"invalid request", `{"type":"error","error":{"type":"invalid_request_error","message":"Bad"}}`, 400, false},
+		{"unknown type", `{"type":"error","error":{"type":"new_error","message":"Odd"}}`, 500, true},
+		{"no details", `{"type":"error"}`, 500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := sseServer(t, anthropicEvents(
+				`{"type":"message_start","message":{"id":"msg_1","model":"claude-test","usage":{}}}`,
+				tt.event,
+			)...)
+
+			_, err := testAnthropicProvider(server.URL).StreamChat(context.Background(), CompletionRequest{Model: "anthropic/claude-test"}, func(StreamResponse) error {
+				return nil
+			})
+			var upstreamErr *UpstreamError
+			if !errors.As(err, &upstreamErr) {
+				t.Fatalf("err = %v, want an UpstreamError", err)
+			}
+			if upstreamErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", upstreamErr.StatusCode, tt.status)
+			}
+			if isRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", isRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestToAnthropicRequest(t *testing.T) {
+	p := NewAnthropicProvider("test-key", "")
+	maxTokens := 100
+	temperature := 0.5
+	req := CompletionRequest{
+		Model: "anthropic/claude-3.5-sonnet",
+		Messages: []ChatMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "What time is it?"},
+			{Role: "system", Content: "Use UTC."},
+			{
+				Role:     "assistant",
+				Content:  "Checking.",
+				Thinking: []ThinkingBlock{{Thinking: "Need the clock.", Signature: "sig"}, {Redacted: "opaque"}},
+				ToolCalls: []models.ToolCall{
+					{ID: "toolu_1", Type: "function", Function: models.ToolCallFunction{Name: "get_time", Arguments: `{"zone":"UTC"}`}},
+					{ID: "toolu_2", Type: "function", Function: models.ToolCallFunction{Name: "get_date", Arguments: `not json`}},
+				},
+			},
+			{Role: "tool", ToolCallID: "toolu_1", Content: "12:00"},
+			{Role: "tool", ToolCallID: "toolu_2", Content: "Monday"},
+			{Role: "user", Content: "Thanks", Parts: []ContentPart{
+				{Type: "image_url", ImageURL: &ImageURLPart{URL: "data:image/png;base64,aW1n"}},
+				{Type: "file", File: &FilePart{FileName: "a.pdf", FileData: "data:application/pdf;base64,cGRm"}},
+				{Type: "image_url", ImageURL: &ImageURLPart{URL: "https://example.com/not-inline.png"}},
+			}},
+		},
+		Tools: []ToolDefinition{
+			{Type: "function", Function: ToolFunction{Name: "get_time", Description: "Current time", Parameters: json.RawMessage(`{"type":"object","properties":{"zone":{"type":"string"}}}`)}},
+			{Type: "function", Function: ToolFunction{Name: "get_date"}},
+		},
+		ToolChoice: "none",
+	}
+	req.MaxTokens = &maxTokens
+	req.Temperature = &temperature
+
+	got := p.toAnthropicRequest(req)
+
+	if got.Model != "claude-3-5-sonnet-latest" {
+		t.Errorf("model = %q, want the alias resolved", got.Model)
+	}
+	if got.System != "Be brief.\n\nUse UTC." {
+		t.Errorf("system = %q, want both system messages joined", got.System)
+	}
+	if got.MaxTokens != 100 || got.Temperature == nil || *got.Temperature != 0.5 {
+		t.Errorf("max tokens, temperature = %d, %v", got.MaxTokens, got.Temperature)
+	}
+	if got.ToolChoice == nil || got.ToolChoice.Type != "none" {
+		t.Errorf("tool choice = %+v, want none", got.ToolChoice)
+	}
+	if len(got.Tools) != 2 || got.Tools[0].Description != "Current time" || string(got.Tools[1].InputSchema) != `{"type":"object"}` {
+		t.Errorf("tools = %+v", got.Tools)
+	}
+
+	var roles []string
+	for _, msg := range got.Messages {
+		var types []string
+		for _, block := range msg.Content {
+			types = append(types, block.Type)
+		}
+		roles = append(roles, msg.Role+": "+strings.Join(types, ","))
+	}
+	want := []string{
+		"user: text",
+		"assistant: thinking,redacted_thinking,text,tool_use,tool_use",
+		"user: tool_result,tool_result,text,image,document",
+	}
+	if strings.Join(roles, " | ") != strings.Join(want, " | ") {
+		t.Fatalf("messages = %q, want %q", roles, want)
+	}
+
+	assistant := got.Messages[1].Content
+	if assistant[0].Signature != "sig" || assistant[1].Data != "opaque" {
+		t.Errorf("thinking blocks = %+v, %+v", assistant[0], assistant[1])
+	}
+	if assistant[3].ID != "toolu_1" || assistant[3].Name != "get_time" || string(assistant[3].Input) != `{"zone":"UTC"}` {
+		t.Errorf("tool_use = %+v", assistant[3])
+	}
+	if string(assistant[4].Input) != "{}" {
+		t.Errorf("invalid arguments sent as %s, want {}", assistant[4].Input)
+	}
+
+	results := got.Messages[2].Content
+	if results[0].ToolUseID != "toolu_1" || results[0].Content != "12:00" || results[1].ToolUseID != "toolu_2" {
+		t.Errorf("tool results = %+v, %+v", results[0], results[1])
+	}
+	if source := results[3].Source; source == nil || *source != (anthropicSource{Type: "base64", MediaType: "image/png", Data: "aW1n"}) {
+		t.Errorf("image source = %+v", source)
+	}
+	if source := results[4].Source; source == nil || source.MediaType != "application/pdf" || source.Data != "cGRm" {
+		t.Errorf("document source = %+v", source)
+	}
+}
+
+func TestToAnthropicRequestThinking(t *testing.T) {
+	p := NewAnthropicProvider("test-key", "")
+	temperature := 0.5
+	req := CompletionRequest{Model: "anthropic/claude-test", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	req.Temperature = &temperature
+	req.Reasoning = &models.ReasoningParams{Effort: "high"}
+
+	got := p.toAnthropicRequest(req)
+	if got.Thinking == nil || got.Thinking.BudgetTokens != 16384 {
+		t.Fatalf("thinking = %+v, want the high budget", got.Thinking)
+	}
+	if got.MaxTokens <= got.Thinking.BudgetTokens {
+		t.Errorf("max tokens = %d, want room beyond the budget", got.MaxTokens)
+	}
+	if got.Temperature != nil {
+		t.Error("temperature sent with thinking")
+	}
+}
+
+func TestToAnthropicRequestThinkingBudget(t *testing.T) {
+	p := NewAnthropicProvider("test-key", "")
+	budget := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		reasoning *models.ReasoningParams
+		want      int // Thinking budget, zero when thinking is off
+	}{
+		{"no reasoning", nil, 0},
+		{"exclude alone", &models.ReasoningParams{Exclude: true}, 0},
+		{"effort", &models.ReasoningParams{Effort: "low"}, 1024},
+		{"effort excluded", &models.ReasoningParams{Effort: "medium", Exclude: true}, 4096},
+		{"unknown effort", &models.ReasoningParams{Effort: "maximal"}, 4096},
+		{"explicit budget", &models.ReasoningParams{Effort: "low", MaxTokens: budget(2000)}, 2000},
+		{"budget below the minimum", &models.ReasoningParams{MaxTokens: budget(500)}, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			temperature := 0.5
+			req := CompletionRequest{Model: "anthropic/claude-test", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+			req.Temperature = &temperature
+			req.Reasoning = tt.reasoning
+
+			got := p.toAnthropicRequest(req)
+			if tt.want == 0 {
+				if got.Thinking != nil {
+					t.Errorf("thinking = %+v, want it off", got.Thinking)
+				}
+				if got.Temperature == nil || got.MaxTokens != anthropicDefaultMaxTokens {
+					t.Errorf("temperature, max tokens = %v, %d, want them untouched", got.Temperature, got.MaxTokens)
+				}
+				return
+			}
+			if got.Thinking == nil || got.Thinking.BudgetTokens != tt.want {
+				t.Errorf("thinking = %+v, want a budget of %d", got.Thinking, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicValidateParams(t *testing.T) {
+	p := NewAnthropicProvider("test-key", "")
+	limit := func(n int) *int { return &n }
+	temperature := 0.5
+	tests := []struct {
+		name    string
+		params  models.GenerationParams
+		wantErr bool
+	}{
+		{"no thinking", models.GenerationParams{MaxTokens: limit(100)}, false},
+		{"thinking without a limit", models.GenerationParams{Reasoning: &models.ReasoningParams{Effort: "high"}}, false},
+		{"limit above the budget", models.GenerationParams{MaxTokens: limit(5000), Reasoning: &models.ReasoningParams{Effort: "medium"}}, false},
+		{"limit at the budget", models.GenerationParams{MaxTokens: limit(4096), Reasoning: &models.ReasoningParams{Effort: "medium"}}, true},
+		{"budget below the minimum", models.GenerationParams{Reasoning: &models.ReasoningParams{MaxTokens: limit(500)}}, true},
+		{"budget at the minimum", models.GenerationParams{Reasoning: &models.ReasoningParams{MaxTokens: limit(1024)}}, false},
+		{"exclude alone", models.GenerationParams{MaxTokens: limit(100), Reasoning: &models.ReasoningParams{Exclude: true}}, false},
+		{"temperature without thinking", models.GenerationParams{Temperature: &temperature, TopP: &temperature}, false},
+		{"temperature with thinking", models.GenerationParams{Temperature: &temperature, Reasoning: &models.ReasoningParams{Effort: "low"}}, true},
+		{"top_p with thinking", models.GenerationParams{TopP: &temperature, Reasoning: &models.ReasoningParams{Effort: "low"}}, true},
+		{"seed", models.GenerationParams{Seed: limit(7)}, true},
+		{"frequency penalty", models.GenerationParams{FrequencyPenalty: &temperature}, true},
+		{"presence penalty", models.GenerationParams{PresencePenalty: &temperature}, true},
+		{"provider routing", models.GenerationParams{Provider: json.RawMessage(`{"order":["Anthropic"]}`)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.ValidateParams(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateParams = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidParams) {
+				t.Errorf("error %v is not ErrInvalidParams", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrAttachmentTooLarge is returned when an upload exceeds MaxAttachmentBytes.
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// ErrUnsupportedAttachment is returned for files that cannot be sent to a
+// model: anything but images, PDFs and text.
+var ErrUnsupportedAttachment = errors.New("unsupported attachment type")
+
+// imageTypes are the image formats vision models accept.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// attachmentType returns the media type of a file detected from its content,
+// without parameters. Of the text types only plain text is accepted, which
+// covers Markdown, CSV and source code; HTML and XML are refused so they
+// can never be served as pages.
+func attachmentType(data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "", ErrUnsupportedAttachment
+	}
+	if imageTypes[mediaType] || mediaType == "application/pdf" || mediaType == "text/plain" {
+		return mediaType, nil
+	}
+	return "", ErrUnsupportedAttachment
+}
+
+// IsInlineAttachment reports whether a stored attachment may be shown in the
+// browser with its own content type: images and PDFs. Anything else is
+// downloaded as plain text.
+func IsInlineAttachment(a models.Attachment) bool {
+	return imageTypes[a.ContentType] || a.ContentType == "application/pdf"
+}
+
+// SaveAttachment stores an uploaded file under AttachmentsDir, named after
+// its SHA-256 so identical uploads share a file, and records it unlinked.
+func (s *ChatService) SaveAttachment(fileName string, r io.Reader) (*models.Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.MaxAttachmentBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload: %v", err)
+	}
+	if int64(len(data)) > s.MaxAttachmentBytes {
+		return nil, ErrAttachmentTooLarge
+	}
+	contentType, err := attachmentType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file and its row are written together, so cleaning up orphans
+	// cannot remove a file a new upload is about to share
+	s.attachmentsMu.Lock()
+	defer s.attachmentsMu.Unlock()
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	storagePath := filepath.Join(hash[:2], hash)
+	fullPath := filepath.Join(s.AttachmentsDir, storagePath)
+	if _, err := os.Stat(fullPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating attachments directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, data, 0o644); err != nil {
+			return nil, fmt.Errorf("error writing attachment: %v", err)
+		}
+	}
+
+	attachment := models.Attachment{
+		FileName:    filepath.Base(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hash,
+		StoragePath: storagePath,
+	}
+	if err := s.DB.Create(&attachment).Error; err != nil {
+		return nil, fmt.Errorf("error saving attachment: %v", err)
+	}
+	return &attachment, nil
+}
+
+// AttachmentPath returns where an attachment's file is stored.
+func (s *ChatService) AttachmentPath(a models.Attachment) string {
+	return filepath.Join(s.AttachmentsDir, a.StoragePath)
+}
+
+// DeleteOrphanedAttachments removes uploads that were never sent with a
+// message within AttachmentRetention, deleting each file once no other
+// attachment shares it. It returns how many attachments were removed.
+func (s *ChatService) DeleteOrphanedAttachments() (int, error) {
+	s.attachmentsMu.Lock()
+	defer s.attachmentsMu.Unlock()
+
+	var orphans []models.Attachment
+	if err := s.DB.Unscoped().Where("message_id IS NULL").Find(&orphans).Error; err != nil {
+		return 0, fmt.Errorf("error loading unsent attachments: %v", err)
+	}
+	cutoff := time.Now().Add(-s.AttachmentRetention)
+	removed := 0
+	for _, a := range orphans {
+		createdAt, err := time.Parse(time.RFC3339, a.CreatedAt)
+		if err != nil || !createdAt.Before(cutoff) {
+			continue
+		}
+		if err := s.DB.Unscoped().Delete(&models.Attachment{}, a.ID).Error; err != nil {
+			return removed, fmt.Errorf("error deleting attachment %d: %v", a.ID, err)
+		}
+		removed++
+
+		var shared int64
+		if err := s.DB.Unscoped().Model(&models.Attachment{}).Where("storage_path = ?", a.StoragePath).Count(&shared).Error; err != nil {
+			return removed, fmt.Errorf("error checking attachment files: %v", err)
+		}
+		if shared == 0 {
+			if err := os.Remove(s.AttachmentPath(a)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return removed, fmt.Errorf("error deleting attachment file: %v", err)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// attachmentCleanupInterval is how often CleanUpAttachments runs.
+const attachmentCleanupInterval = time.Hour
+
+// CleanUpAttachments deletes orphaned attachments now and then every
+// attachmentCleanupInterval until ctx is done. A zero AttachmentRetention
+// keeps them.
+func (s *ChatService) CleanUpAttachments(ctx context.Context) {
+	if s.AttachmentRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(attachmentCleanupInterval)
+	defer ticker.Stop()
+	for {
+		if removed, err := s.DeleteOrphanedAttachments(); err != nil {
+			fmt.Printf("Error cleaning up attachments: %v\n", err)
+		} else if removed > 0 {
+			fmt.Printf("Deleted %d unsent attachments\n", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAttachments makes sure every ID names an attachment that has not
+// been sent with a message yet, and that none is listed twice.
+func (s *ChatService) CheckAttachments(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	listed := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if listed[id] {
+			return fmt.Errorf("attachment %d listed more than once", id)
+		}
+		listed[id] = true
+	}
+	var available []uint
+	if err := s.DB.Model(&models.Attachment{}).Where("id IN ? AND message_id IS NULL", ids).Pluck("id", &available).Error; err != nil {
+		return fmt.Errorf("error loading attachments: %v", err)
+	}
+	found := make(map[uint]bool, len(available))
+	for _, id := range available {
+		found[id] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return fmt.Errorf("attachment %d not found or already sent", id)
+		}
+	}
+	return nil
+}
+
+// linkAttachments attaches uploaded files to the message they were sent
+// with, as part of the transaction tx that saves the message.
+func linkAttachments(tx *gorm.DB, messageID uint, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := tx.Model(&models.Attachment{}).Where("id IN ? AND message_id IS NULL", ids).Update("message_id", messageID)
+	if result.Error != nil {
+		return fmt.Errorf("error linking attachments: %v", result.Error)
+	}
+	if result.RowsAffected != int64(len(ids)) {
+		return fmt.Errorf("attachments of message %d not found or already sent", messageID)
+	}
+	return nil
+}
+
+// loadAttachments returns the attachments of the given messages by message ID.
+func (s *ChatService) loadAttachments(messageIDs []uint) (map[uint][]models.Attachment, error) {
+	byMessage := make(map[uint][]models.Attachment)
+	if len(messageIDs) == 0 {
+		return byMessage, nil
+	}
+	var attachments []models.Attachment
+	if err := s.DB.Where("message_id IN ?", messageIDs).Order("id ASC").Find(&attachments).Error; err != nil {
+		return nil, fmt.Errorf("error loading attachments: %v", err)
+	}
+	for _, a := range attachments {
+		byMessage[*a.MessageID] = append(byMessage[*a.MessageID], a)
+	}
+	return byMessage, nil
+}
+
+// attachmentParts turns attachments into content parts: images and PDFs as
+// data URLs, text files inline.
+func (s *ChatService) attachmentParts(attachments []models.Attachment) ([]ContentPart, error) {
+	var parts []ContentPart
+	for _, a := range attachments {
+		data, err := os.ReadFile(s.AttachmentPath(a))
+		if err != nil {
+			return nil, fmt.Errorf("error reading attachment %d: %v", a.ID, err)
+		}
+		dataURL := fmt.Sprintf("data:%s;base64,%s", a.ContentType, base64.StdEncoding.EncodeToString(data))
+		switch {
+		case imageTypes[a.ContentType]:
+			parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURLPart{URL: dataURL}, fileName: a.FileName})
+		case a.ContentType == "application/pdf":
+			parts = append(parts, ContentPart{Type: "file", File: &FilePart{FileName: a.FileName, FileData: dataURL}, fileName: a.FileName})
+		default:
+			parts = append(parts, ContentPart{Type: "text", Text: fmt.Sprintf("Attached file %s:\n\n%s", a.FileName, data), fileName: a.FileName})
+		}
+	}
+	return parts, nil
+}
+
+// withoutImages replaces image parts with a note, for models that do not
+// accept image input. messages is left untouched.
+func withoutImages(messages []ChatMessage) []ChatMessage {
+	stripped := make([]ChatMessage, len(messages))
+	for i, msg := range messages {
+		stripped[i] = msg
+		if len(msg.Parts) == 0 {
+			continue
+		}
+		stripped[i].Parts = make([]ContentPart, len(msg.Parts))
+		for j, part := range msg.Parts {
+			if part.Type == "image_url" {
+				part = ContentPart{Type: "text", Text: fmt.Sprintf("[Image %s omitted: this model does not accept images]", part.fileName)}
+			}
+			stripped[i].Parts[j] = part
+		}
+	}
+	return stripped
+}
+
+func hasImages(messages []ChatMessage) bool {
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			if part.Type == "image_url" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// messagesFor adapts the prompt to a candidate model, dropping images the
+// model cannot read.
+func (s *ChatService) messagesFor(c candidate, messages []ChatMessage) []ChatMessage {
+	if hasImages(messages) && !s.acceptsImages(c) {
+		return withoutImages(messages)
+	}
+	return messages
+}
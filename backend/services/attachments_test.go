@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+func TestAttachmentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "png", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", want: "image/png"},
+		{name: "jpeg", data: "\xff\xd8\xff\xe0\x00\x10JFIF", want: "image/jpeg"},
+		{name: "pdf", data: "%PDF-1.7\n", want: "application/pdf"},
+		{name: "markdown", data: "# Notes\n\n- one\n", want: "text/plain"},
+		{name: "html", data: "<html><script>alert(1)</script></html>", wantErr: true},
+		{name: "svg", data: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, wantErr: true},
+		{name: "zip", data: "PK\x03\x04\x14\x00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := attachmentType([]byte(tt.data))
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedAttachment) {
+					t.Errorf("attachmentType = %q, %v, want ErrUnsupportedAttachment", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("attachmentType = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// testAttachments returns a service storing attachments in a temporary
+// directory.
+func testAttachments(t *testing.T) *ChatService {
+	t.Helper()
+	s := NewChatService(testDB(t), &MockProvider{})
+	s.AttachmentsDir = t.TempDir()
+	return s
+}
+
+func TestSaveAttachmentSharesIdenticalFiles(t *testing.T) {
+	s := testAttachments(t)
+	s.MaxAttachmentBytes = 64
+
+	first, err := s.SaveAttachment("notes.md", strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("first SaveAttachment: %v", err)
+	}
+	second, err := s.SaveAttachment("../copy.md", strings.NewReader("same content"))
+	if err != nil {
+		t.Fatalf("second SaveAttachment: %v", err)
+	}
+	if first.ID == second.ID || first.StoragePath != second.StoragePath {
+		t.Errorf("attachments %d and %d stored at %q and %q, want two rows sharing a file", first.ID, second.ID, first.StoragePath, second.StoragePath)
+	}
+	if second.FileName != "copy.md" {
+		t.Errorf("file name = %q, want the directories dropped", second.FileName)
+	}
+	if data, err := os.ReadFile(s.AttachmentPath(*first)); err != nil || string(data) != "same content" {
+		t.Errorf("stored file = %q, %v", data, err)
+	}
+
+	if _, err := s.SaveAttachment("big.txt", strings.NewReader(strings.Repeat("x", 65))); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Errorf("oversized upload = %v, want ErrAttachmentTooLarge", err)
+	}
+	if _, err := s.SaveAttachment("page.html", strings.NewReader("<html></html>")); !errors.Is(err, ErrUnsupportedAttachment) {
+		t.Errorf("HTML upload = %v, want ErrUnsupportedAttachment", err)
+	}
+}
+
+func TestChatLinksAttachmentsOnce(t *testing.T) {
+	s := testAttachments(t)
+	attachment, err := s.SaveAttachment("notes.txt", strings.NewReader("remember the milk"))
+	if err != nil {
+		t.Fatalf("SaveAttachment: %v", err)
+	}
+
+	result, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: "summarize", AttachmentIDs: []uint{attachment.ID}}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("ChatSync: %v", err)
+	}
+	var linked models.Attachment
+	if err := s.DB.First(&linked, attachment.ID).Error; err != nil {
+		t.Fatalf("loading attachment: %v", err)
+	}
+	if linked.MessageID == nil || *linked.MessageID != result.UserMessageID {
+		t.Errorf("attachment linked to %v, want message %d", linked.MessageID, result.UserMessageID)
+	}
+
+	if err := s.CheckAttachments([]uint{attachment.ID}); err == nil {
+		t.Error("CheckAttachments accepted an attachment already sent")
+	}
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		return linkAttachments(tx, result.AssistantMessageID, []uint{attachment.ID})
+	}); err == nil {
+		t.Error("linkAttachments linked an attachment already sent")
+	}
+}
+
+func TestWithoutImages(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "user", Content: "what is this?", Parts: []ContentPart{
+			{Type: "image_url", ImageURL: &ImageURLPart{URL: "data:image/png;base64,AA=="}, fileName: "cat.png"},
+			{Type: "text", Text: "Attached file notes.txt:\n\nhi", fileName: "notes.txt"},
+		}},
+		{Role: "assistant", Content: "a cat"},
+	}
+
+	stripped := withoutImages(messages)
+	if hasImages(stripped) {
+		t.Fatal("images left in the prompt")
+	}
+	parts := stripped[0].Parts
+	if len(parts) != 2 || parts[0].Type != "text" || !strings.Contains(parts[0].Text, "cat.png") || parts[1].Text != messages[0].Parts[1].Text {
+		t.Errorf("parts = %+v, want the image replaced by a note naming it", parts)
+	}
+	if !hasImages(messages) {
+		t.Error("withoutImages changed the original messages")
+	}
+}
+
+func TestDeleteOrphanedAttachments(t *testing.T) {
+	s := testAttachments(t)
+	save := func(name, content string, age time.Duration) *models.Attachment {
+		t.Helper()
+		a, err := s.SaveAttachment(name, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("SaveAttachment: %v", err)
+		}
+		a.CreatedAt = time.Now().Add(-age).Format(time.RFC3339)
+		if err := s.DB.Model(a).UpdateColumn("created_at", a.CreatedAt).Error; err != nil {
+			t.Fatalf("backdating attachment: %v", err)
+		}
+		return a
+	}
+	abandoned := save("abandoned.txt", "abandoned", 48*time.Hour)
+	sharedOld := save("shared.txt", "shared", 48*time.Hour)
+	sharedNew := save("shared-again.txt", "shared", time.Minute)
+	pending := save("pending.txt", "pending", time.Minute)
+	sent := save("sent.txt", "sent", 48*time.Hour)
+	result, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: "hi", AttachmentIDs: []uint{sent.ID}}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("ChatSync: %v", err)
+	}
+
+	removed, err := s.DeleteOrphanedAttachments()
+	if err != nil {
+		t.Fatalf("DeleteOrphanedAttachments: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d attachments, want the 2 old unsent ones", removed)
+	}
+	var kept []uint
+	if err := s.DB.Unscoped().Model(&models.Attachment{}).Order("id ASC").Pluck("id", &kept).Error; err != nil {
+		t.Fatalf("loading attachments: %v", err)
+	}
+	if want := []uint{sharedNew.ID, pending.ID, sent.ID}; fmt.Sprint(kept) != fmt.Sprint(want) {
+		t.Errorf("kept attachments %v, want %v", kept, want)
+	}
+	for _, tt := range []struct {
+		a    *models.Attachment
+		kept bool
+	}{{abandoned, false}, {sharedOld, true}, {pending, true}, {sent, true}} {
+		if _, err := os.Stat(s.AttachmentPath(*tt.a)); (err == nil) != tt.kept {
+			t.Errorf("file of %s: stat error %v, want kept %v", tt.a.FileName, err, tt.kept)
+		}
+	}
+
+	// The sent attachment is still part of its message
+	var message models.Message
+	if err := s.DB.Preload("Attachments").First(&message, result.UserMessageID).Error; err != nil {
+		t.Fatalf("loading message: %v", err)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].ID != sent.ID {
+		t.Errorf("message attachments = %+v, want %d", message.Attachments, sent.ID)
+	}
+}
@@ -0,0 +1,951 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// ChatService persists conversations and forwards them to the Provider
+// selected for each chat.
+type ChatService struct {
+	DB              *gorm.DB
+	Providers       map[string]Provider
+	DefaultProvider string
+
+	// DetachTimeout is how long a generation keeps running with no client
+	// attached before it is cancelled.
+	DetachTimeout time.Duration
+	// StreamRetention is how long a finished generation's events stay
+	// available for replay.
+	StreamRetention time.Duration
+	// PersistInterval and PersistBytes control how often streamed content is
+	// written to the database: whichever limit is reached first.
+	PersistInterval time.Duration
+	PersistBytes    int
+	// MaxRetries is how many times a retryable upstream failure is retried
+	// per model, waiting a random delay up to RetryBaseDelay doubled on each
+	// attempt and capped at RetryMaxDelay.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// ModelCacheTTL is how long a provider's model list is served from the
+	// database before it is fetched again.
+	ModelCacheTTL time.Duration
+	// Tools are the server tools chats can enable. MaxToolIterations is how
+	// many rounds of tool calls a single reply may run before the model is
+	// told to answer without them.
+	Tools             *ToolRegistry
+	MaxToolIterations int
+	// AttachmentsDir is where uploaded files are stored, and
+	// MaxAttachmentBytes the largest upload accepted. Uploads not sent with
+	// a message within AttachmentRetention are deleted.
+	AttachmentsDir      string
+	MaxAttachmentBytes  int64
+	AttachmentRetention time.Duration
+	// MaxImportBytes is the largest export Import accepts, and the most the
+	// conversations in a zip may decompress to.
+	MaxImportBytes int64
+
+	generations   *generationRegistry
+	attachmentsMu sync.Mutex // Guards attachment files against cleanup
+}
+
+type ChatRequest struct {
+	Model          string    `json:"model"`
+	Messages       []Message `json:"messages"`
+	Stream         bool      `json:"stream"`
+	ChatID         uint      `json:"chat_id,omitempty"`
+	Provider       string    `json:"provider,omitempty"`
+	FallbackModels []string  `json:"fallback_models,omitempty"` // Replaces the chat's fallback list when set
+	// Params are forwarded upstream and stored on the assistant message
+	Params models.GenerationParams `json:"params"`
+	// ServerHistory makes the stored conversation authoritative: Messages
+	// holds only the new messages, and the prompt is rebuilt from the
+	// database instead of taken from the client.
+	ServerHistory bool `json:"server_history,omitempty"`
+	// IncludeReasoning sends stored reasoning back upstream with the server
+	// history; by default only the answers are re-sent.
+	IncludeReasoning bool `json:"include_reasoning,omitempty"`
+	// Tools replaces the chat's enabled tools when set
+	Tools []string `json:"tools,omitempty"`
+}
+
+type Message struct {
+	ID         uint              `json:"id,omitempty"`
+	ChatID     uint              `json:"chat_id,omitempty"`
+	Role       string            `json:"role"`
+	Content    string            `json:"content"`
+	ModelName  string            `json:"model_name"`
+	ToolCalls  []models.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	// AttachmentIDs are files uploaded to /api/attachments to send with a
+	// new message
+	AttachmentIDs []uint `json:"attachment_ids,omitempty"`
+}
+
+// NewChatService creates a service with the given providers registered. The
+// first provider becomes the default.
+func NewChatService(db *gorm.DB, providers ...Provider) *ChatService {
+	s := &ChatService{
+		DB:                  db,
+		Providers:           make(map[string]Provider),
+		DetachTimeout:       30 * time.Second,
+		StreamRetention:     5 * time.Minute,
+		PersistInterval:     time.Second,
+		PersistBytes:        1024,
+		MaxRetries:          2,
+		RetryBaseDelay:      500 * time.Millisecond,
+		RetryMaxDelay:       8 * time.Second,
+		ModelCacheTTL:       time.Hour,
+		Tools:               NewToolRegistry(BuiltinTools()...),
+		MaxToolIterations:   5,
+		AttachmentsDir:      "attachments",
+		MaxAttachmentBytes:  10 << 20,
+		AttachmentRetention: 24 * time.Hour,
+		MaxImportBytes:      100 << 20,
+		generations:         newGenerationRegistry(),
+	}
+	for _, p := range providers {
+		s.RegisterProvider(p)
+	}
+	return s
+}
+
+// ConfigureFromEnv applies GENERATION_DETACH_TIMEOUT, GENERATION_RETENTION,
+// PERSIST_INTERVAL, PERSIST_BYTES, RETRY_MAX, RETRY_BASE_DELAY,
+// RETRY_MAX_DELAY, MODEL_CACHE_TTL, MAX_TOOL_ITERATIONS, ATTACHMENTS_DIR,
+// MAX_ATTACHMENT_BYTES, ATTACHMENT_RETENTION and MAX_IMPORT_BYTES, keeping
+// the defaults for unset values.
+func (s *ChatService) ConfigureFromEnv() error {
+	var err error
+	if s.DetachTimeout, err = envDuration("GENERATION_DETACH_TIMEOUT", s.DetachTimeout); err != nil {
+		return err
+	}
+	if s.StreamRetention, err = envDuration("GENERATION_RETENTION", s.StreamRetention); err != nil {
+		return err
+	}
+	if s.PersistInterval, err = envDuration("PERSIST_INTERVAL", s.PersistInterval); err != nil {
+		return err
+	}
+	if n := envInt("PERSIST_BYTES"); n > 0 {
+		s.PersistBytes = n
+	}
+	if os.Getenv("RETRY_MAX") != "" {
+		s.MaxRetries = envInt("RETRY_MAX")
+	}
+	if s.RetryBaseDelay, err = envDuration("RETRY_BASE_DELAY", s.RetryBaseDelay); err != nil {
+		return err
+	}
+	if s.RetryMaxDelay, err = envDuration("RETRY_MAX_DELAY", s.RetryMaxDelay); err != nil {
+		return err
+	}
+	if s.ModelCacheTTL, err = envDuration("MODEL_CACHE_TTL", s.ModelCacheTTL); err != nil {
+		return err
+	}
+	if n := envInt("MAX_TOOL_ITERATIONS"); n > 0 {
+		s.MaxToolIterations = n
+	}
+	if dir := os.Getenv("ATTACHMENTS_DIR"); dir != "" {
+		s.AttachmentsDir = dir
+	}
+	if n := envInt("MAX_ATTACHMENT_BYTES"); n > 0 {
+		s.MaxAttachmentBytes = int64(n)
+	}
+	if s.AttachmentRetention, err = envDuration("ATTACHMENT_RETENTION", s.AttachmentRetention); err != nil {
+		return err
+	}
+	if n := envInt("MAX_IMPORT_BYTES"); n > 0 {
+		s.MaxImportBytes = int64(n)
+	}
+	return nil
+}
+
+// MarkInterruptedMessages flags assistant messages left streaming by a
+// previous run. Generations live in memory, so none of them can still be
+// in progress at startup.
+func (s *ChatService) MarkInterruptedMessages() error {
+	result := s.DB.Model(&models.Message{}).
+		Where("status = ?", models.MessageStatusStreaming).
+		Updates(map[string]interface{}{
+			"status": models.MessageStatusError,
+			"error":  "interrupted by a server restart",
+		})
+	if result.Error != nil {
+		return fmt.Errorf("error marking interrupted messages: %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		fmt.Printf("Marked %d interrupted messages\n", result.RowsAffected)
+	}
+	return nil
+}
+
+// RegisterProvider adds or replaces a provider under its name.
+func (s *ChatService) RegisterProvider(p Provider) {
+	s.Providers[p.Name()] = p
+	if s.DefaultProvider == "" {
+		s.DefaultProvider = p.Name()
+	}
+}
+
+// ResolveProvider picks the provider for a request. An explicit name wins,
+// then a model prefix naming a registered provider (e.g. "mock/echo"). A
+// model without a slash goes to the chat's stored provider; anything else,
+// such as an OpenRouter "openai/gpt-4o", goes to the default.
+func (s *ChatService) ResolveProvider(name, model, chatProvider string) (Provider, error) {
+	if name == "" {
+		prefix, _, ok := strings.Cut(model, "/")
+		if _, registered := s.Providers[prefix]; ok && registered {
+			name = prefix
+		} else if !ok {
+			name = chatProvider
+		}
+	}
+	if name == "" {
+		name = s.DefaultProvider
+	}
+	p, ok := s.Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %q", name)
+	}
+	return p, nil
+}
+
+// StopGeneration aborts the in-flight generation of an assistant message,
+// keeping the content streamed so far.
+func (s *ChatService) StopGeneration(messageID uint) error {
+	if !s.generations.stop(messageID) {
+		return ErrGenerationNotFound
+	}
+	return nil
+}
+
+// pendingReply is an assistant answer ready to be generated: the request's
+// new messages and an empty assistant message are saved, and the models to
+// try are chosen.
+type pendingReply struct {
+	chatID      uint
+	candidates  []candidate // The requested model first, then the chat's fallbacks
+	request     CompletionRequest
+	tools       []Tool           // Tools the model may call
+	created     []models.Message // Saved messages, the assistant message last
+	assistant   models.Message
+	toolResults []ToolResultEvent // Tools run so far, in order
+}
+
+// prepareReply checks req, then saves the new messages in req along with
+// the chat's settings, creating the chat when chatID is 0.
+func (s *ChatService) prepareReply(req ChatRequest, chatID uint) (*pendingReply, error) {
+	// A new chat is only saved along with the messages, once the request
+	// has been checked
+	chat := models.Chat{ModelName: req.Model}
+	if chatID != 0 {
+		if err := s.DB.First(&chat, chatID).Error; err != nil {
+			return nil, fmt.Errorf("error loading existing chat: %v", err)
+		}
+	}
+
+	// The chat's settings fill in what the request leaves out
+	if req.Model == "" {
+		req.Model = chat.ModelName
+	}
+	req.Params = req.Params.WithDefaults(chat.DefaultParams)
+
+	// Only a provider named by the request switches the chat over; one
+	// selected by a model prefix applies to that model alone
+	provider, err := s.ResolveProvider(req.Provider, req.Model, chat.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	providerChanged := req.Provider != "" && chat.ProviderName != req.Provider
+	if providerChanged {
+		chat.ProviderName = req.Provider
+	}
+	if req.FallbackModels != nil {
+		chat.FallbackModels = req.FallbackModels
+	}
+	candidates, err := s.fallbackChain(provider, req.Model, chat.FallbackModels, chat.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every model that may answer must take the parameters as they are
+	// stored, and the chat keeps its settings when one cannot
+	for _, c := range candidates {
+		if v, ok := c.provider.(ParamsValidator); ok {
+			if err := v.ValidateParams(req.Params); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Tools named by the request are checked before they replace the chat's
+	toolNames := chat.Tools
+	if req.Tools != nil {
+		toolNames = req.Tools
+	}
+	tools, err := s.Tools.Resolve(toolNames)
+	if err != nil {
+		return nil, err
+	}
+	if req.Tools != nil {
+		chat.Tools = req.Tools
+	}
+
+	// Save the chat's settings and the new messages, remembering the ID of
+	// every message in the request. They are saved together with their
+	// attachments or not at all.
+	var created []models.Message
+	messageIDs := make([]uint, len(req.Messages))
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if chat.ID == 0 {
+			if err := tx.Create(&chat).Error; err != nil {
+				return fmt.Errorf("error creating new chat: %v", err)
+			}
+		} else if err := saveChatSettings(tx, &chat, providerChanged, req); err != nil {
+			return err
+		}
+		chatID = chat.ID
+
+		for i, msg := range req.Messages {
+			// Skip messages that already exist in the database
+			if msg.ID != 0 {
+				messageIDs[i] = msg.ID
+				continue
+			}
+
+			// Create new message
+			message := models.Message{
+				ChatID:     chatID,
+				Role:       msg.Role,
+				Content:    msg.Content,
+				ModelName:  req.Model,
+				Status:     models.MessageStatusComplete,
+				ToolCalls:  msg.ToolCalls,
+				ToolCallID: msg.ToolCallID,
+			}
+			if err := tx.Create(&message).Error; err != nil {
+				return fmt.Errorf("error saving message: %v", err)
+			}
+			if err := linkAttachments(tx, message.ID, msg.AttachmentIDs); err != nil {
+				return err
+			}
+			messageIDs[i] = message.ID
+			created = append(created, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the prompt before the empty assistant message exists
+	var history []ChatMessage
+	if req.ServerHistory {
+		if history, err = s.loadHistory(chatID, req.IncludeReasoning); err != nil {
+			return nil, err
+		}
+	} else {
+		attachments, err := s.loadAttachments(messageIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range req.Messages {
+			parts, err := s.attachmentParts(attachments[messageIDs[i]])
+			if err != nil {
+				return nil, err
+			}
+			history = append(history, ChatMessage{Role: msg.Role, Content: msg.Content, ToolCalls: msg.ToolCalls, ToolCallID: msg.ToolCallID, Parts: parts})
+		}
+	}
+	if chat.SystemPrompt != "" {
+		system := ChatMessage{Role: "system", Content: chat.SystemPrompt}
+		history = append([]ChatMessage{system}, history...)
+	}
+
+	// Create and save the assistant message
+	assistantMessage := models.Message{
+		ChatID:    chatID,
+		Role:      "assistant",
+		Content:   "", // This will be populated as we stream
+		ModelName: req.Model,
+		Status:    models.MessageStatusStreaming,
+		Params:    &req.Params,
+	}
+	if err := s.DB.Create(&assistantMessage).Error; err != nil {
+		return nil, fmt.Errorf("error saving assistant message: %v", err)
+	}
+	created = append(created, assistantMessage)
+
+	completionReq := CompletionRequest{
+		Model:            req.Model,
+		Messages:         history,
+		Stream:           req.Stream,
+		GenerationParams: req.Params,
+		Tools:            toolDefinitions(tools),
+	}
+
+	return &pendingReply{
+		chatID:     chatID,
+		candidates: candidates,
+		request:    completionReq,
+		tools:      tools,
+		created:    created,
+		assistant:  assistantMessage,
+	}, nil
+}
+
+// saveChatSettings stores the settings req changed on an existing chat.
+func saveChatSettings(tx *gorm.DB, chat *models.Chat, providerChanged bool, req ChatRequest) error {
+	if providerChanged {
+		if err := tx.Model(chat).Update("provider_name", chat.ProviderName).Error; err != nil {
+			return fmt.Errorf("error updating chat provider: %v", err)
+		}
+	}
+	if req.FallbackModels != nil {
+		if err := tx.Model(chat).Select("fallback_models").Updates(chat).Error; err != nil {
+			return fmt.Errorf("error updating chat fallback models: %v", err)
+		}
+	}
+	if req.Tools != nil {
+		if err := tx.Model(chat).Select("tools").Updates(chat).Error; err != nil {
+			return fmt.Errorf("error updating chat tools: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadHistory rebuilds a chat's conversation from the database in the order
+// it was written. Answers still being generated or that failed are left
+// out; stopped answers are kept with the content the user saw. Reasoning is
+// only included when asked for. Tool calls are kept only when their result
+// was saved, since upstreams reject calls left unanswered.
+func (s *ChatService) loadHistory(chatID uint, includeReasoning bool) ([]ChatMessage, error) {
+	var stored []models.Message
+	if err := s.DB.Preload("Attachments").Where("chat_id = ?", chatID).Order("created_at ASC, id ASC").Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("error loading chat history: %v", err)
+	}
+
+	answered := make(map[string]bool)
+	for _, msg := range stored {
+		if msg.Role == "tool" {
+			answered[msg.ToolCallID] = true
+		}
+	}
+
+	history := make([]ChatMessage, 0, len(stored))
+	for _, msg := range stored {
+		if msg.Status == models.MessageStatusStreaming || msg.Status == models.MessageStatusError {
+			continue
+		}
+		var toolCalls []models.ToolCall
+		for _, call := range msg.ToolCalls {
+			if answered[call.ID] {
+				toolCalls = append(toolCalls, call)
+			}
+		}
+		if msg.Content == "" && len(toolCalls) == 0 && len(msg.Attachments) == 0 && msg.Role != "tool" {
+			continue
+		}
+		parts, err := s.attachmentParts(msg.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		message := ChatMessage{Role: msg.Role, Content: msg.Content, ToolCalls: toolCalls, ToolCallID: msg.ToolCallID, Parts: parts}
+		if includeReasoning {
+			message.Reasoning = msg.Reasoning
+		}
+		history = append(history, message)
+	}
+	return history, nil
+}
+
+// fallbackChain lists the models to try for a reply: the requested model on
+// the chosen provider, then each fallback on the provider ResolveProvider
+// selects for it in the chat.
+func (s *ChatService) fallbackChain(provider Provider, model string, fallbacks []string, chatProvider string) ([]candidate, error) {
+	candidates := []candidate{{provider: provider, model: model}}
+	for _, fallback := range fallbacks {
+		if fallback == "" || fallback == model {
+			continue
+		}
+		p, err := s.ResolveProvider("", fallback, chatProvider)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback model %q: %v", fallback, err)
+		}
+		candidates = append(candidates, candidate{provider: p, model: fallback})
+	}
+	return candidates, nil
+}
+
+// Chat saves the new messages in req, starts generating the assistant's
+// answer in the background and streams it to w. The generation outlives
+// the request: if the client disconnects it keeps running for
+// DetachTimeout, during which StreamMessage can reattach to it.
+//
+// Nothing is written to w before the messages are saved, so an error from
+// that step can still be answered with a plain HTTP error. Once the event
+// stream has started, generation failures are sent as an error event.
+func (s *ChatService) Chat(ctx context.Context, req ChatRequest, chatID uint, w http.ResponseWriter) error {
+	req.Stream = true
+	reply, err := s.prepareReply(req, chatID)
+	if err != nil {
+		return err
+	}
+	if chatID == 0 {
+		w.Header().Set("X-Chat-ID", strconv.FormatUint(uint64(reply.chatID), 10))
+	}
+	setSSEHeaders(w)
+
+	// The generation is not bound to ctx; it is cancelled by StopGeneration or
+	// when no client stays attached
+	genCtx, cancel := context.WithCancelCause(context.Background())
+	gen := newGeneration(reply.assistant.ID, cancel, s.DetachTimeout)
+	for _, message := range reply.created {
+		gen.emit(EventMessageCreated, MessageCreatedEvent{MessageID: message.ID, ChatID: reply.chatID, Role: message.Role})
+	}
+	s.generations.add(gen)
+	go s.runGeneration(genCtx, gen, reply)
+
+	return s.attach(ctx, gen, 0, w)
+}
+
+// ChatResult is the response of a non-streaming chat request. When the
+// model called tools, the assistant message is the last one of the reply
+// and ToolResults lists the tools run before it.
+type ChatResult struct {
+	ChatID             uint              `json:"chatId"`
+	UserMessageID      uint              `json:"userMessageId,omitempty"`
+	AssistantMessageID uint              `json:"assistantMessageId"`
+	Model              string            `json:"model"`
+	Content            string            `json:"content"`
+	Reasoning          string            `json:"reasoning,omitempty"`
+	FinishReason       string            `json:"finishReason"`
+	PromptTokens       int               `json:"promptTokens"`
+	CompletionTokens   int               `json:"completionTokens"`
+	ReasoningTokens    int               `json:"reasoningTokens,omitempty"`
+	TotalTokens        int               `json:"totalTokens"`
+	ToolResults        []ToolResultEvent `json:"toolResults,omitempty"`
+}
+
+// ChatSync saves the new messages in req and waits for the complete
+// assistant answer. Cancelling ctx aborts the upstream request. When the
+// answer fails after the messages were saved, the result identifies them
+// alongside the error.
+func (s *ChatService) ChatSync(ctx context.Context, req ChatRequest, chatID uint) (*ChatResult, error) {
+	req.Stream = false
+	reply, err := s.prepareReply(req, chatID)
+	if err != nil {
+		return nil, err
+	}
+	result := &ChatResult{ChatID: reply.chatID, AssistantMessageID: reply.assistant.ID}
+	for _, message := range reply.created {
+		if message.Role == "user" {
+			result.UserMessageID = message.ID
+		}
+	}
+
+	// The generation runs in the foreground, bound to ctx. It is registered
+	// all the same so StopGeneration can end it; clients attaching to it
+	// with StreamMessage and leaving again do not.
+	genCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	gen := newGeneration(reply.assistant.ID, cancel, s.DetachTimeout)
+	gen.foreground = true
+	s.generations.add(gen)
+	last, err := s.runGeneration(genCtx, gen, reply)
+	if last.ID != 0 {
+		result.AssistantMessageID = last.ID
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var answer models.Message
+	if err := s.DB.First(&answer, last.ID).Error; err != nil {
+		return result, fmt.Errorf("error loading assistant message: %v", err)
+	}
+	result.Model = answer.ModelName
+	result.Content = answer.Content
+	result.Reasoning = answer.Reasoning
+	result.FinishReason = answer.FinishReason
+	result.PromptTokens = answer.PromptTokens
+	result.CompletionTokens = answer.CompletionTokens
+	result.ReasoningTokens = answer.ReasoningTokens
+	result.TotalTokens = answer.TotalTokens
+	result.ToolResults = reply.toolResults
+	return result, nil
+}
+
+// StreamMessage reattaches to the generation of an assistant message,
+// replaying every event after the given sequence number.
+func (s *ChatService) StreamMessage(ctx context.Context, messageID uint, after int, w http.ResponseWriter) error {
+	gen, ok := s.generations.get(messageID)
+	if !ok {
+		return ErrGenerationNotFound
+	}
+	setSSEHeaders(w)
+	return s.attach(ctx, gen, after, w)
+}
+
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
+
+// attach follows a generation until it finishes. A client going away is
+// not an error; the generation keeps running without it.
+func (s *ChatService) attach(ctx context.Context, gen *generation, after int, w http.ResponseWriter) error {
+	if err := gen.attach(ctx, after, w); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// turn is one answer of the model within a reply.
+type turn struct {
+	answered     candidate
+	output       streamedOutput
+	finishReason string
+	usage        *UsageData
+	metrics      generationMetrics
+}
+
+// runGeneration produces the assistant's answer into gen and persists it.
+// When the model calls tools they are run, and the model is asked again
+// with their results in a new assistant message, until it answers without
+// calling any. It returns the last assistant message of the reply.
+func (s *ChatService) runGeneration(ctx context.Context, gen *generation, reply *pendingReply) (assistant models.Message, err error) {
+	defer s.generations.removeAfter(gen, s.StreamRetention)
+	defer gen.finish()
+
+	assistant = reply.assistant
+	var t turn
+
+	// A panic must not leave the message streaming forever
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Panic while generating message %d: %v\n", assistant.ID, r)
+			err = fmt.Errorf("internal error: %v", r)
+			s.failGeneration(gen, assistant, t.output, ErrorTypeInternal, err)
+		}
+	}()
+
+	for round := 0; ; round++ {
+		t = turn{}
+		err = s.runTurn(ctx, gen, reply, assistant, &t)
+		if err != nil && ctx.Err() != nil {
+			fmt.Printf("Generation of message %d cancelled: %v\n", assistant.ID, context.Cause(ctx))
+			updates := map[string]interface{}{
+				"content":   t.output.content,
+				"reasoning": t.output.reasoning,
+				"status":    models.MessageStatusCancelled,
+			}
+			t.metrics.apply(updates, 0)
+			if err := s.DB.Model(&assistant).Updates(updates).Error; err != nil {
+				fmt.Printf("Error saving cancelled assistant message: %v\n", err)
+			}
+			gen.emit(EventDone, DoneEvent{MessageID: assistant.ID, FinishReason: models.MessageStatusCancelled})
+			return assistant, context.Cause(ctx)
+		}
+		if err != nil {
+			fmt.Printf("Error from provider %s: %v\n", t.answered.provider.Name(), err)
+			s.failGeneration(gen, assistant, t.output, ErrorTypeProvider, err)
+			return assistant, err
+		}
+		if len(t.output.toolCalls) > 0 && round >= s.MaxToolIterations {
+			err = fmt.Errorf("model kept calling tools after %d rounds", s.MaxToolIterations)
+			s.failGeneration(gen, assistant, t.output, ErrorTypeProvider, err)
+			return assistant, err
+		}
+
+		if err = s.saveTurn(gen, assistant, &t); err != nil {
+			return assistant, err
+		}
+		if len(t.output.toolCalls) == 0 {
+			gen.emit(EventDone, DoneEvent{MessageID: assistant.ID, FinishReason: t.finishReason, Model: t.answered.model})
+			return assistant, nil
+		}
+
+		next, err := s.runTools(ctx, gen, reply, assistant, t.output, round+1)
+		if err != nil {
+			fmt.Printf("Error running tools of message %d: %v\n", assistant.ID, err)
+			s.failGeneration(gen, assistant, t.output, ErrorTypeInternal, err)
+			return assistant, err
+		}
+		assistant = next
+	}
+}
+
+// runTurn asks the model for one answer into assistant, retrying and
+// falling back through the reply's candidates until output starts flowing.
+// Non-streaming requests are answered in a single chunk.
+func (s *ChatService) runTurn(ctx context.Context, gen *generation, reply *pendingReply, assistant models.Message, t *turn) error {
+	// Save the output streamed so far every PersistInterval or PersistBytes
+	lastPersist := time.Now()
+	persistedLen := 0
+
+	// Translate each chunk into delta events while accumulating the full response
+	onChunk := func(chunk StreamResponse) error {
+		hasContent := false
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				t.finishReason = choice.FinishReason
+			}
+			if reasoning := choice.Delta.ReasoningText(); reasoning != "" {
+				hasContent = true
+				t.output.reasoning += reasoning
+				gen.emit(EventReasoning, DeltaEvent{MessageID: assistant.ID, Content: reasoning})
+			}
+			if choice.Delta.Content != "" {
+				hasContent = true
+				t.output.content += choice.Delta.Content
+				gen.emit(EventDelta, DeltaEvent{MessageID: assistant.ID, Content: choice.Delta.Content})
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				hasContent = true
+				t.output.addToolCall(call)
+			}
+			t.output.thinking = append(t.output.thinking, choice.Delta.Thinking...)
+		}
+		// Time to first token is measured here only when streaming
+		t.metrics.observe(chunk, hasContent && reply.request.Stream)
+
+		if t.output.size()-persistedLen >= s.PersistBytes || time.Since(lastPersist) >= s.PersistInterval {
+			partial := map[string]interface{}{"content": t.output.content, "reasoning": t.output.reasoning}
+			if err := s.DB.Model(&assistant).Updates(partial).Error; err != nil {
+				fmt.Printf("Error persisting partial content of message %d: %v\n", assistant.ID, err)
+			}
+			lastPersist = time.Now()
+			persistedLen = t.output.size()
+		}
+		return nil
+	}
+
+	// Failures are retried, or handed to the next fallback model, only while
+	// nothing has been streamed to the client
+	var err error
+	t.answered, err = s.withFallbacks(ctx, reply.candidates, func(c candidate) (bool, error) {
+		attempt := reply.request
+		attempt.Model = c.model
+		attempt.Messages = s.messagesFor(c, attempt.Messages)
+		t.metrics.begin(c.provider)
+		var err error
+		if attempt.Stream {
+			t.usage, err = c.provider.StreamChat(ctx, attempt, onChunk)
+		} else {
+			var resp *ChatResponse
+			if resp, err = c.provider.Complete(ctx, attempt); err == nil {
+				if resp.Error != nil {
+					err = fmt.Errorf("API error: %s", resp.Error.Message)
+				} else {
+					t.usage = resp.Usage
+					err = onChunk(chunkFromResponse(resp))
+				}
+			}
+		}
+		t.metrics.end(attempt.Stream)
+		return t.output.size() > 0, err
+	})
+
+	// Calls are answered by ID, so make sure every call has one
+	for i := range t.output.toolCalls {
+		if t.output.toolCalls[i].ID == "" {
+			t.output.toolCalls[i].ID = fmt.Sprintf("call_%d_%d", assistant.ID, i)
+		}
+	}
+	return err
+}
+
+// saveTurn completes the assistant message with the turn's answer, recording
+// the model that actually answered, and reports its usage.
+func (s *ChatService) saveTurn(gen *generation, assistant models.Message, t *turn) error {
+	updates := map[string]interface{}{
+		"content":       t.output.content,
+		"reasoning":     t.output.reasoning,
+		"model_name":    t.answered.model,
+		"finish_reason": t.finishReason,
+		"status":        models.MessageStatusComplete,
+	}
+
+	// If the provider reported token usage, store it on the assistant's message
+	completionTokens := 0
+	if t.usage != nil {
+		updates["prompt_tokens"] = t.usage.PromptTokens
+		updates["completion_tokens"] = t.usage.CompletionTokens
+		updates["total_tokens"] = t.usage.TotalTokens
+		updates["reasoning_tokens"] = t.usage.ReasoningTokens()
+		completionTokens = t.usage.CompletionTokens
+	}
+	t.metrics.apply(updates, completionTokens)
+	err := s.DB.Model(&assistant).Updates(updates).Error
+	if err == nil && len(t.output.toolCalls) > 0 {
+		assistant.ToolCalls = t.output.toolCalls
+		err = s.DB.Model(&assistant).Select("tool_calls").Updates(&assistant).Error
+	}
+	if err != nil {
+		fmt.Printf("Error updating assistant message: %v\n", err)
+		err = fmt.Errorf("error updating assistant message: %v", err)
+		s.failGeneration(gen, assistant, t.output, ErrorTypeInternal, err)
+		return err
+	}
+
+	if t.usage != nil {
+		gen.emit(EventUsage, UsageEvent{
+			MessageID:        assistant.ID,
+			PromptTokens:     t.usage.PromptTokens,
+			CompletionTokens: t.usage.CompletionTokens,
+			ReasoningTokens:  t.usage.ReasoningTokens(),
+			TotalTokens:      t.usage.TotalTokens,
+		})
+	}
+	return nil
+}
+
+// runTools runs the tool calls of the caller's answer, saving a tool
+// message with each result, and creates the assistant message the model
+// answers into next. Once rounds reaches MaxToolIterations the model is told
+// not to call tools anymore.
+func (s *ChatService) runTools(ctx context.Context, gen *generation, reply *pendingReply, caller models.Message, output streamedOutput, rounds int) (models.Message, error) {
+	reply.request.Messages = append(reply.request.Messages, ChatMessage{Role: "assistant", Content: output.content, ToolCalls: output.toolCalls, Thinking: output.thinking})
+
+	for _, call := range output.toolCalls {
+		gen.emit(EventToolCall, ToolCallEvent{MessageID: caller.ID, ToolCallID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+
+		result := models.Message{
+			ChatID:          reply.chatID,
+			Role:            "tool",
+			Content:         runTool(ctx, reply.tools, call),
+			ToolCallID:      call.ID,
+			ToolName:        call.Function.Name,
+			CallerMessageID: &caller.ID,
+			Status:          models.MessageStatusComplete,
+		}
+		if err := s.DB.Create(&result).Error; err != nil {
+			return models.Message{}, fmt.Errorf("error saving tool message: %v", err)
+		}
+		event := ToolResultEvent{MessageID: result.ID, CallerMessageID: caller.ID, ToolCallID: call.ID, Name: call.Function.Name, Content: result.Content}
+		gen.emit(EventMessageCreated, MessageCreatedEvent{MessageID: result.ID, ChatID: reply.chatID, Role: result.Role})
+		gen.emit(EventToolResult, event)
+		reply.toolResults = append(reply.toolResults, event)
+		reply.request.Messages = append(reply.request.Messages, ChatMessage{Role: "tool", Content: result.Content, ToolCallID: call.ID})
+	}
+	if rounds >= s.MaxToolIterations {
+		reply.request.ToolChoice = "none"
+	}
+
+	next := models.Message{
+		ChatID:    reply.chatID,
+		Role:      "assistant",
+		ModelName: reply.request.Model,
+		Status:    models.MessageStatusStreaming,
+		Params:    caller.Params,
+	}
+	if err := s.DB.Create(&next).Error; err != nil {
+		return models.Message{}, fmt.Errorf("error saving assistant message: %v", err)
+	}
+	s.generations.alias(next.ID, gen)
+	gen.emit(EventMessageCreated, MessageCreatedEvent{MessageID: next.ID, ChatID: reply.chatID, Role: next.Role})
+	return next, nil
+}
+
+// runTool runs one call and returns what the model is told. Failures,
+// including calls to tools the chat has not enabled, are reported to the
+// model rather than ending the reply.
+func runTool(ctx context.Context, tools []Tool, call models.ToolCall) string {
+	for _, tool := range tools {
+		if tool.Name != call.Function.Name {
+			continue
+		}
+		args := json.RawMessage(call.Function.Arguments)
+		if len(args) == 0 {
+			args = json.RawMessage("{}")
+		}
+		result, err := tool.Run(ctx, args)
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		return result
+	}
+	return fmt.Sprintf("Error: unknown tool %q", call.Function.Name)
+}
+
+// streamedOutput is the assistant output received so far.
+type streamedOutput struct {
+	content   string
+	reasoning string
+	toolCalls []models.ToolCall
+	thinking  []ThinkingBlock
+}
+
+func (o streamedOutput) size() int {
+	n := len(o.content) + len(o.reasoning)
+	for _, call := range o.toolCalls {
+		n += len(call.Function.Name) + len(call.Function.Arguments)
+	}
+	for _, block := range o.thinking {
+		n += len(block.Thinking) + len(block.Signature) + len(block.Redacted)
+	}
+	return n
+}
+
+// addToolCall merges a streamed fragment into the call at its index.
+func (o *streamedOutput) addToolCall(delta ToolCallDelta) {
+	if delta.Index < 0 {
+		delta.Index = 0
+	}
+	for len(o.toolCalls) <= delta.Index {
+		o.toolCalls = append(o.toolCalls, models.ToolCall{Type: "function"})
+	}
+	call := &o.toolCalls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	call.Function.Name += delta.Function.Name
+	call.Function.Arguments += delta.Function.Arguments
+}
+
+// failGeneration marks the assistant message as failed, keeping the output
+// streamed so far and the error text, and ends the stream with an error event.
+func (s *ChatService) failGeneration(gen *generation, assistantMessage models.Message, output streamedOutput, errType string, err error) {
+	updates := map[string]interface{}{
+		"content":   output.content,
+		"reasoning": output.reasoning,
+		"status":    models.MessageStatusError,
+		"error":     err.Error(),
+	}
+	if dbErr := s.DB.Model(&assistantMessage).Updates(updates).Error; dbErr != nil {
+		fmt.Printf("Error saving failed assistant message: %v\n", dbErr)
+	}
+
+	event := ErrorEvent{MessageID: assistantMessage.ID, Type: errType, Error: err.Error()}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		event.StatusCode = upstreamErr.StatusCode
+	}
+	gen.emit(EventError, event)
+}
+
+// Add new method to get chat history
+func (s *ChatService) GetChatHistory(chatID uint) (*models.Chat, error) {
+	var chat models.Chat
+	if err := s.DB.Preload("Messages").First(&chat, chatID).Error; err != nil {
+		return nil, fmt.Errorf("error fetching chat history: %v", err)
+	}
+	return &chat, nil
+}
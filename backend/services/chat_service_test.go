@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"web/ai-playground/migrations"
+	"web/ai-playground/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens a migrated SQLite database in a temporary directory.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chat.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if _, err := migrations.New(db).Up(0); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestResolveProvider(t *testing.T) {
+	s := NewChatService(nil,
+		NewOpenRouterProvider(""),
+		&MockProvider{},
+		NewOpenAICompatibleProvider(EndpointConfig{Name: "ollama", BaseURL: "http://127.0.0.1:9/v1"}),
+	)
+
+	tests := []struct {
+		name         string
+		provider     string
+		model        string
+		chatProvider string
+		want         string
+	}{
+		{"explicit name", "mock", "openai/gpt-4o", "ollama", "mock"},
+		{"registered prefix", "", "ollama/llama3", "", "ollama"},
+		{"registered prefix over the chat's provider", "", "mock/echo", "ollama", "mock"},
+		{"unregistered prefix with a chat provider", "", "openai/gpt-4o", "ollama", "openrouter"},
+		{"unregistered prefix", "", "openai/gpt-4o", "", "openrouter"},
+		{"no prefix with a chat provider", "", "llama3", "ollama", "ollama"},
+		{"no prefix", "", "gpt-4o", "", "openrouter"},
+		{"no model", "", "", "", "openrouter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := s.ResolveProvider(tt.provider, tt.model, tt.chatProvider)
+			if err != nil {
+				t.Fatalf("ResolveProvider: %v", err)
+			}
+			if p.Name() != tt.want {
+				t.Errorf("provider = %q, want %q", p.Name(), tt.want)
+			}
+		})
+	}
+
+	if _, err := s.ResolveProvider("missing", "mock/echo", ""); err == nil {
+		t.Error("unknown explicit provider resolved")
+	}
+}
+
+func TestFallbackChainResolvesLikeTheRequestedModel(t *testing.T) {
+	s := NewChatService(nil,
+		NewOpenRouterProvider(""),
+		NewOpenAICompatibleProvider(EndpointConfig{Name: "ollama", BaseURL: "http://127.0.0.1:9/v1"}),
+	)
+	requested := s.Providers["ollama"]
+	candidates, err := s.fallbackChain(requested, "llama3", []string{"openai/gpt-4o", "mistral", "ollama/qwen"}, "ollama")
+	if err != nil {
+		t.Fatalf("fallbackChain: %v", err)
+	}
+	var got []string
+	for _, c := range candidates {
+		got = append(got, c.provider.Name()+" "+c.model)
+	}
+	want := []string{"ollama llama3", "openrouter openai/gpt-4o", "ollama mistral", "ollama ollama/qwen"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("candidates = %q, want %q", got, want)
+	}
+}
+
+func TestChatSyncSavesMessages(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{})
+
+	result, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: "hello there"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("ChatSync: %v", err)
+	}
+	if result.Content != "hello there" {
+		t.Errorf("content = %q, want the echoed message", result.Content)
+	}
+
+	var stored []models.Message
+	if err := db.Where("chat_id = ?", result.ChatID).Order("id ASC").Find(&stored).Error; err != nil {
+		t.Fatalf("loading messages: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("saved %d messages, want 2", len(stored))
+	}
+	user, assistant := stored[0], stored[1]
+	if user.ID != result.UserMessageID || user.Role != "user" || user.Content != "hello there" {
+		t.Errorf("user message = %+v", user)
+	}
+	if assistant.ID != result.AssistantMessageID || assistant.Role != "assistant" || assistant.Content != "hello there" {
+		t.Errorf("assistant message = %+v", assistant)
+	}
+	if assistant.Status != models.MessageStatusComplete || assistant.FinishReason != "stop" || assistant.ProviderName != "mock" {
+		t.Errorf("assistant status, finish reason, provider = %q, %q, %q", assistant.Status, assistant.FinishReason, assistant.ProviderName)
+	}
+	if assistant.CompletionTokens != result.CompletionTokens || assistant.CompletionTokens == 0 {
+		t.Errorf("completion tokens = %d, result says %d", assistant.CompletionTokens, result.CompletionTokens)
+	}
+}
+
+func TestChatSyncDoesNotKeepPrefixedProvider(t *testing.T) {
+	db := testDB(t)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"local-1","choices":[{"message":{"role":"assistant","content":"from local"},"finish_reason":"stop"}]}`)
+	}))
+	defer local.Close()
+	s := NewChatService(db, &MockProvider{}, NewOpenAICompatibleProvider(EndpointConfig{Name: "local", BaseURL: local.URL}))
+
+	first, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "local/model",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("first ChatSync: %v", err)
+	}
+	if first.Content != "from local" {
+		t.Errorf("first answer = %q, want it from the local endpoint", first.Content)
+	}
+
+	second, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:         "openai/gpt-4o",
+		Messages:      []Message{{Role: "user", Content: "again"}},
+		ServerHistory: true,
+	}, first.ChatID)
+	if err != nil {
+		t.Fatalf("second ChatSync: %v", err)
+	}
+	var answer models.Message
+	if err := db.First(&answer, second.AssistantMessageID).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.ProviderName != "mock" {
+		t.Errorf("second answer served by %q, want the default provider", answer.ProviderName)
+	}
+
+	var chat models.Chat
+	if err := db.First(&chat, first.ChatID).Error; err != nil {
+		t.Fatalf("loading chat: %v", err)
+	}
+	if chat.ProviderName != "" {
+		t.Errorf("chat provider = %q, want none stored", chat.ProviderName)
+	}
+}
+
+func TestChatSyncSurvivesStreamClientsLeaving(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{ChunkDelay: 100 * time.Millisecond})
+	s.DetachTimeout = 0
+
+	type outcome struct {
+		result *ChatResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := s.ChatSync(context.Background(), ChatRequest{
+			Model:    "mock/echo",
+			Messages: []Message{{Role: "user", Content: "still here"}},
+		}, 0)
+		done <- outcome{result, err}
+	}()
+
+	// The registry holds only this generation, under its assistant message
+	var messageID uint
+	deadline := time.Now().Add(5 * time.Second)
+	for messageID == 0 {
+		s.generations.mu.Lock()
+		for id := range s.generations.generations {
+			messageID = id
+		}
+		s.generations.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("generation never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, leave := context.WithCancel(context.Background())
+	leave()
+	if err := s.StreamMessage(ctx, messageID, 0, httptest.NewRecorder()); err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+
+	got := <-done
+	if got.err != nil {
+		t.Fatalf("ChatSync: %v", got.err)
+	}
+	if got.result.Content != "still here" {
+		t.Errorf("content = %q, want the echoed message", got.result.Content)
+	}
+	if got.result.AssistantMessageID != messageID {
+		t.Errorf("answered in message %d, streamed message %d", got.result.AssistantMessageID, messageID)
+	}
+	var answer models.Message
+	if err := db.First(&answer, got.result.AssistantMessageID).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.Status != models.MessageStatusComplete {
+		t.Errorf("status = %q, want complete", answer.Status)
+	}
+}
+
+func TestChatSyncRefusesParamsAFallbackWouldChange(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{}, NewAnthropicProvider("test-key", "http://127.0.0.1:9"))
+	chat := models.Chat{}
+	if err := db.Create(&chat).Error; err != nil {
+		t.Fatalf("creating chat: %v", err)
+	}
+
+	maxTokens := 2000
+	_, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:          "mock/echo",
+		FallbackModels: []string{"anthropic/claude-test"},
+		Messages:       []Message{{Role: "user", Content: "hi"}},
+		Params:         models.GenerationParams{MaxTokens: &maxTokens, Reasoning: &models.ReasoningParams{Effort: "medium"}},
+	}, chat.ID)
+	if !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("ChatSync = %v, want ErrInvalidParams", err)
+	}
+
+	var messages int64
+	if err := db.Model(&models.Message{}).Count(&messages).Error; err != nil {
+		t.Fatalf("counting messages: %v", err)
+	}
+	if messages != 0 {
+		t.Errorf("saved %d messages, want none", messages)
+	}
+	if err := db.First(&chat, chat.ID).Error; err != nil {
+		t.Fatalf("loading chat: %v", err)
+	}
+	if len(chat.FallbackModels) != 0 {
+		t.Errorf("fallback models = %q, want them unchanged", chat.FallbackModels)
+	}
+}
+
+func TestChatSyncRefusesTemperatureWithAnthropicThinking(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, NewAnthropicProvider("test-key", "http://127.0.0.1:9"))
+
+	temperature := 0.7
+	_, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "anthropic/claude-test",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Params:   models.GenerationParams{Temperature: &temperature, Reasoning: &models.ReasoningParams{Effort: "high"}},
+	}, 0)
+	if !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("ChatSync = %v, want ErrInvalidParams", err)
+	}
+	var messages int64
+	if err := db.Model(&models.Message{}).Count(&messages).Error; err != nil {
+		t.Fatalf("counting messages: %v", err)
+	}
+	if messages != 0 {
+		t.Errorf("saved %d messages, want none", messages)
+	}
+	var chats int64
+	if err := db.Model(&models.Chat{}).Count(&chats).Error; err != nil {
+		t.Fatalf("counting chats: %v", err)
+	}
+	if chats != 0 {
+		t.Errorf("created %d chats, want none", chats)
+	}
+}
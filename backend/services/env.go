@@ -0,0 +1,37 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envInt reads an integer environment variable, treating unset or invalid
+// values as zero.
+func envInt(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Ignoring invalid %s: %v\n", key, err)
+		return 0
+	}
+	return n
+}
+
+// envDuration reads a Go duration (e.g. "30s") from the environment,
+// returning def when the variable is unset.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,408 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnknownExportFormat is returned for export formats other than md,
+// json and html.
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+// ExportFormat and ExportVersion identify files in our JSON export format.
+const (
+	ExportFormat  = "ai-playground-chat"
+	ExportVersion = 1
+)
+
+// ExportContentTypes maps the export formats to their content types.
+var ExportContentTypes = map[string]string{
+	"md":   "text/markdown; charset=utf-8",
+	"json": "application/json",
+	"html": "text/html; charset=utf-8",
+}
+
+// ChatExport is a chat as written by JSON exports, with its forks when they
+// were asked for. Format and Version are only set on the exported chat
+// itself, not on its forks.
+type ChatExport struct {
+	Format         string                   `json:"format,omitempty"`
+	Version        int                      `json:"version,omitempty"`
+	ID             uint                     `json:"id"`
+	ParentID       *uint                    `json:"parentId,omitempty"`
+	ForkMessageID  *uint                    `json:"forkMessageId,omitempty"`
+	SourceID       *string                  `json:"sourceId,omitempty"` // Where the chat was imported from
+	Title          string                   `json:"title,omitempty"`
+	ModelName      string                   `json:"modelName"`
+	ProviderName   string                   `json:"providerName,omitempty"`
+	FallbackModels []string                 `json:"fallbackModels,omitempty"`
+	SystemPrompt   string                   `json:"systemPrompt,omitempty"`
+	DefaultParams  *models.GenerationParams `json:"defaultParams,omitempty"`
+	Tools          []string                 `json:"tools,omitempty"`
+	Starred        bool                     `json:"starred"`
+	CreatedAt      string                   `json:"createdAt"`
+	UpdatedAt      string                   `json:"updatedAt"`
+	Messages       []MessageExport          `json:"messages"`
+	Forks          []ChatExport             `json:"forks,omitempty"`
+}
+
+// MessageExport is a message as written by JSON exports. Attachments are
+// described but their files are not included.
+type MessageExport struct {
+	ID               uint               `json:"id"`
+	Role             string             `json:"role"`
+	Content          string             `json:"content"`
+	Reasoning        string             `json:"reasoning,omitempty"`
+	ModelName        string             `json:"modelName,omitempty"`
+	Starred          bool               `json:"starred"`
+	CreatedAt        string             `json:"createdAt"`
+	Status           string             `json:"status,omitempty"`
+	FinishReason     string             `json:"finishReason,omitempty"`
+	Error            string             `json:"error,omitempty"`
+	PromptTokens     int                `json:"promptTokens"`
+	CompletionTokens int                `json:"completionTokens"`
+	ReasoningTokens  int                `json:"reasoningTokens"`
+	TotalTokens      int                `json:"totalTokens"`
+	ToolCalls        []models.ToolCall  `json:"toolCalls,omitempty"`
+	ToolCallID       string             `json:"toolCallId,omitempty"`
+	ToolName         string             `json:"toolName,omitempty"`
+	Attachments      []AttachmentExport `json:"attachments,omitempty"`
+}
+
+type AttachmentExport struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// LoadChatExport loads a chat for export, with its forks and theirs when
+// withForks is set.
+func (s *ChatService) LoadChatExport(chatID uint, withForks bool) (*ChatExport, error) {
+	var chat models.Chat
+	if err := s.DB.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC, id ASC")
+	}).Preload("Messages.Attachments").First(&chat, chatID).Error; err != nil {
+		return nil, err
+	}
+
+	export := ChatExport{
+		ID:             chat.ID,
+		ParentID:       chat.ParentID,
+		ForkMessageID:  chat.ForkMessageID,
+		SourceID:       chat.SourceID,
+		Title:          chat.Title,
+		ModelName:      chat.ModelName,
+		ProviderName:   chat.ProviderName,
+		FallbackModels: chat.FallbackModels,
+		SystemPrompt:   chat.SystemPrompt,
+		DefaultParams:  chat.DefaultParams,
+		Tools:          chat.Tools,
+		Starred:        chat.Starred,
+		CreatedAt:      chat.CreatedAt,
+		UpdatedAt:      chat.UpdatedAt,
+		Messages:       make([]MessageExport, 0, len(chat.Messages)),
+	}
+	for _, msg := range chat.Messages {
+		message := MessageExport{
+			ID:               msg.ID,
+			Role:             msg.Role,
+			Content:          msg.Content,
+			Reasoning:        msg.Reasoning,
+			ModelName:        msg.ModelName,
+			Starred:          msg.Starred,
+			CreatedAt:        msg.CreatedAt,
+			Status:           msg.Status,
+			FinishReason:     msg.FinishReason,
+			Error:            msg.Error,
+			PromptTokens:     msg.PromptTokens,
+			CompletionTokens: msg.CompletionTokens,
+			ReasoningTokens:  msg.ReasoningTokens,
+			TotalTokens:      msg.TotalTokens,
+			ToolCalls:        msg.ToolCalls,
+			ToolCallID:       msg.ToolCallID,
+			ToolName:         msg.ToolName,
+		}
+		for _, a := range msg.Attachments {
+			message.Attachments = append(message.Attachments, AttachmentExport{FileName: a.FileName, ContentType: a.ContentType, Size: a.Size, SHA256: a.SHA256})
+		}
+		export.Messages = append(export.Messages, message)
+	}
+
+	if withForks {
+		var forkIDs []uint
+		if err := s.DB.Model(&models.Chat{}).Where("parent_id = ?", chat.ID).Order("created_at ASC, id ASC").Pluck("id", &forkIDs).Error; err != nil {
+			return nil, fmt.Errorf("error loading forks of chat %d: %v", chat.ID, err)
+		}
+		for _, id := range forkIDs {
+			fork, err := s.LoadChatExport(id, true)
+			if err != nil {
+				return nil, fmt.Errorf("error loading fork %d: %v", id, err)
+			}
+			export.Forks = append(export.Forks, *fork)
+		}
+	}
+	return &export, nil
+}
+
+// exportSection is a part of a rendered export: the chat, or one of its
+// forks with only the messages that differ from the chat it was forked from.
+type exportSection struct {
+	Title    string
+	Chat     *ChatExport
+	Messages []MessageExport
+}
+
+// exportSections lists the chat and its forks depth first.
+func exportSections(chat *ChatExport) []exportSection {
+	title := chat.Title
+	if title == "" {
+		title = fmt.Sprintf("Chat %d", chat.ID)
+	}
+	sections := []exportSection{{Title: title, Chat: chat, Messages: chat.Messages}}
+	var addForks func(parent *ChatExport)
+	addForks = func(parent *ChatExport) {
+		for i := range parent.Forks {
+			fork := &parent.Forks[i]
+			// A fork starts with copies of the messages before its fork message
+			shared := 0
+			for j, msg := range parent.Messages {
+				if fork.ForkMessageID != nil && msg.ID == *fork.ForkMessageID {
+					shared = j
+					break
+				}
+			}
+			shared = min(shared, len(fork.Messages))
+			sections = append(sections, exportSection{
+				Title:    fmt.Sprintf("Fork %d of chat %d, diverging at message %d", fork.ID, parent.ID, shared+1),
+				Chat:     fork,
+				Messages: fork.Messages[shared:],
+			})
+			addForks(fork)
+		}
+	}
+	addForks(chat)
+	return sections
+}
+
+// speaker names the author of a message in rendered exports.
+func speaker(msg MessageExport) string {
+	switch msg.Role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	case "tool":
+		return fmt.Sprintf("Tool result (%s)", msg.ToolName)
+	default:
+		return msg.Role
+	}
+}
+
+// usage describes the tokens a message used, or is empty when none were
+// reported.
+func usage(msg MessageExport) string {
+	if msg.TotalTokens == 0 {
+		return ""
+	}
+	text := fmt.Sprintf("%d prompt + %d completion = %d tokens", msg.PromptTokens, msg.CompletionTokens, msg.TotalTokens)
+	if msg.ReasoningTokens > 0 {
+		text += fmt.Sprintf(" (%d reasoning)", msg.ReasoningTokens)
+	}
+	return text
+}
+
+// RenderChatExport writes a chat in the given format: md, json or html.
+func RenderChatExport(w io.Writer, chat *ChatExport, format string) error {
+	switch format {
+	case "json":
+		export := *chat
+		export.Format = ExportFormat
+		export.Version = ExportVersion
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case "md":
+		return renderMarkdown(w, chat)
+	case "html":
+		return exportTemplate.Execute(w, exportSections(chat))
+	default:
+		return ErrUnknownExportFormat
+	}
+}
+
+func renderMarkdown(w io.Writer, chat *ChatExport) error {
+	var b strings.Builder
+	for i, section := range exportSections(chat) {
+		heading := "#"
+		if i > 0 {
+			heading = "##"
+		}
+		fmt.Fprintf(&b, "%s %s\n\n", heading, section.Title)
+		if section.Chat.ModelName != "" {
+			fmt.Fprintf(&b, "- Model: %s\n", section.Chat.ModelName)
+		}
+		fmt.Fprintf(&b, "- Created: %s\n\n", section.Chat.CreatedAt)
+		if section.Chat.SystemPrompt != "" {
+			fmt.Fprintf(&b, "System prompt:\n\n> %s\n\n", strings.ReplaceAll(section.Chat.SystemPrompt, "\n", "\n> "))
+		}
+		for _, msg := range section.Messages {
+			fmt.Fprintf(&b, "---\n\n**%s**", speaker(msg))
+			if msg.Role == "assistant" && msg.ModelName != "" {
+				fmt.Fprintf(&b, " · %s", msg.ModelName)
+			}
+			fmt.Fprintf(&b, " · %s\n\n", msg.CreatedAt)
+			if msg.Content != "" {
+				fmt.Fprintf(&b, "%s\n\n", strings.TrimRight(msg.Content, "\n"))
+			}
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&b, "Called `%s` with `%s`\n\n", call.Function.Name, call.Function.Arguments)
+			}
+			for _, a := range msg.Attachments {
+				fmt.Fprintf(&b, "Attached %s (%s, %d bytes)\n\n", a.FileName, a.ContentType, a.Size)
+			}
+			if msg.Error != "" {
+				fmt.Fprintf(&b, "_Failed: %s_\n\n", msg.Error)
+			}
+			if u := usage(msg); u != "" {
+				fmt.Fprintf(&b, "_%s_\n\n", u)
+			}
+		}
+	}
+	_, err := io.WriteString(w, strings.TrimRight(b.String(), "\n")+"\n")
+	return err
+}
+
+var exportTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"speaker": speaker,
+	"usage":   usage,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{(index . 0).Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.meta, .usage { color: #666; font-size: 0.9em; }
+.message { border-top: 1px solid #ddd; padding: 0.5rem 0; }
+.content { white-space: pre-wrap; }
+blockquote { white-space: pre-wrap; color: #555; }
+</style>
+</head>
+<body>
+{{range $i, $section := .}}
+<section>
+{{if eq $i 0}}<h1>{{$section.Title}}</h1>{{else}}<h2>{{$section.Title}}</h2>{{end}}
+<p class="meta">{{with $section.Chat.ModelName}}Model: {{.}} · {{end}}Created: {{$section.Chat.CreatedAt}}</p>
+{{if $section.Chat.SystemPrompt}}<p class="meta">System prompt:</p><blockquote>{{$section.Chat.SystemPrompt}}</blockquote>{{end}}
+{{range $section.Messages}}
+<div class="message">
+<p class="meta"><strong>{{speaker .}}</strong>{{if and (eq .Role "assistant") .ModelName}} · {{.ModelName}}{{end}} · {{.CreatedAt}}</p>
+{{if .Content}}<div class="content">{{.Content}}</div>{{end}}
+{{range .ToolCalls}}<p>Called <code>{{.Function.Name}}</code> with <code>{{.Function.Arguments}}</code></p>{{end}}
+{{range .Attachments}}<p>Attached {{.FileName}} ({{.ContentType}}, {{.Size}} bytes)</p>{{end}}
+{{if .Error}}<p><em>Failed: {{.Error}}</em></p>{{end}}
+{{with usage .}}<p class="usage">{{.}}</p>{{end}}
+</div>
+{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// ExportChats writes a zip with one file per root chat in the given format,
+// and with their forks when withForks is set. With starredOnly it holds the
+// starred chats instead, forks included; a starred fork is left out only
+// when withForks already puts it in the file of a starred ancestor.
+func (s *ChatService) ExportChats(w io.Writer, format string, starredOnly, withForks bool) error {
+	if _, ok := ExportContentTypes[format]; !ok {
+		return ErrUnknownExportFormat
+	}
+	var chatIDs []uint
+	if starredOnly {
+		ids, err := s.starredExportChats(withForks)
+		if err != nil {
+			return err
+		}
+		chatIDs = ids
+	} else if err := s.DB.Model(&models.Chat{}).Where("parent_id IS NULL").Order("created_at ASC, id ASC").Pluck("id", &chatIDs).Error; err != nil {
+		return fmt.Errorf("error loading chats: %v", err)
+	}
+
+	archive := zip.NewWriter(w)
+	for _, id := range chatIDs {
+		chat, err := s.LoadChatExport(id, withForks)
+		if err != nil {
+			return fmt.Errorf("error loading chat %d: %v", id, err)
+		}
+		file, err := archive.Create(fmt.Sprintf("chat-%d.%s", id, format))
+		if err != nil {
+			return fmt.Errorf("error writing export: %v", err)
+		}
+		if err := RenderChatExport(file, chat, format); err != nil {
+			return fmt.Errorf("error rendering chat %d: %v", id, err)
+		}
+	}
+	return archive.Close()
+}
+
+// starredExportChats lists the starred chats to export as files of their
+// own. With withForks, forks with a starred ancestor are skipped, as they
+// are exported inside its file.
+func (s *ChatService) starredExportChats(withForks bool) ([]uint, error) {
+	var starred []models.Chat
+	if err := s.DB.Select("id", "parent_id").Where("starred = ?", true).Order("created_at ASC, id ASC").Find(&starred).Error; err != nil {
+		return nil, fmt.Errorf("error loading chats: %v", err)
+	}
+	if !withForks {
+		ids := make([]uint, len(starred))
+		for i, chat := range starred {
+			ids[i] = chat.ID
+		}
+		return ids, nil
+	}
+
+	var all []models.Chat
+	if err := s.DB.Select("id", "parent_id", "starred").Find(&all).Error; err != nil {
+		return nil, fmt.Errorf("error loading chats: %v", err)
+	}
+	byID := make(map[uint]models.Chat, len(all))
+	for _, chat := range all {
+		byID[chat.ID] = chat
+	}
+
+	var ids []uint
+	for _, chat := range starred {
+		// The walk stops at a deleted parent, and at a cycle should one exist
+		covered := false
+		seen := map[uint]bool{chat.ID: true}
+		for parentID := chat.ParentID; parentID != nil && !seen[*parentID]; {
+			parent, ok := byID[*parentID]
+			if !ok {
+				break
+			}
+			if parent.Starred {
+				covered = true
+				break
+			}
+			seen[parent.ID] = true
+			parentID = parent.ParentID
+		}
+		if !covered {
+			ids = append(ids, chat.ID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// createChat saves a chat with messages of the given contents, alternating
+// user and assistant, and returns it with the IDs of its messages.
+func createChat(t *testing.T, db *gorm.DB, chat models.Chat, contents ...string) (models.Chat, []uint) {
+	t.Helper()
+	if err := db.Create(&chat).Error; err != nil {
+		t.Fatalf("creating chat: %v", err)
+	}
+	var ids []uint
+	for i, content := range contents {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		msg := models.Message{ChatID: chat.ID, Role: role, Content: content, Status: models.MessageStatusComplete}
+		if err := db.Create(&msg).Error; err != nil {
+			t.Fatalf("creating message: %v", err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	return chat, ids
+}
+
+func TestRenderChatExportEscapesHTML(t *testing.T) {
+	s := NewChatService(testDB(t))
+	chat, _ := createChat(t, s.DB, models.Chat{ModelName: "mock/echo", SystemPrompt: "</blockquote><script>alert('prompt')</script>"},
+		"<script>alert('user')</script>", "<img src=x onerror=alert('answer')>")
+
+	export, err := s.LoadChatExport(chat.ID, false)
+	if err != nil {
+		t.Fatalf("LoadChatExport: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := RenderChatExport(&buf, export, "html"); err != nil {
+		t.Fatalf("RenderChatExport: %v", err)
+	}
+	page := buf.String()
+	for _, raw := range []string{"<script>", "</blockquote><script>", "<img"} {
+		if strings.Contains(page, raw) {
+			t.Errorf("export contains %q unescaped:\n%s", raw, page)
+		}
+	}
+	for _, escaped := range []string{"&lt;script&gt;alert(&#39;user&#39;)", "&lt;img src=x", "&lt;/blockquote&gt;"} {
+		if !strings.Contains(page, escaped) {
+			t.Errorf("export lacks %q:\n%s", escaped, page)
+		}
+	}
+}
+
+func TestRenderChatExportWithFork(t *testing.T) {
+	s := NewChatService(testDB(t))
+	chat, ids := createChat(t, s.DB, models.Chat{ModelName: "mock/echo"}, "Shared question", "Original answer")
+	fork, _ := createChat(t, s.DB, models.Chat{ModelName: "mock/echo", ParentID: &chat.ID, ForkMessageID: &ids[1]}, "Shared question", "Forked answer")
+
+	export, err := s.LoadChatExport(chat.ID, true)
+	if err != nil {
+		t.Fatalf("LoadChatExport: %v", err)
+	}
+	if len(export.Forks) != 1 || export.Forks[0].ID != fork.ID {
+		t.Fatalf("forks = %+v, want fork %d", export.Forks, fork.ID)
+	}
+
+	for _, format := range []string{"md", "html"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := RenderChatExport(&buf, export, format); err != nil {
+				t.Fatalf("RenderChatExport: %v", err)
+			}
+			out := buf.String()
+			title := fmt.Sprintf("Fork %d of chat %d, diverging at message 2", fork.ID, chat.ID)
+			if !strings.Contains(out, title) {
+				t.Errorf("export lacks the fork section %q:\n%s", title, out)
+			}
+			// The fork's copy of the shared question is not repeated
+			if n := strings.Count(out, "Shared question"); n != 1 {
+				t.Errorf("shared question appears %d times, want once:\n%s", n, out)
+			}
+			if !strings.Contains(out, "Original answer") || !strings.Contains(out, "Forked answer") {
+				t.Errorf("export lacks an answer:\n%s", out)
+			}
+		})
+	}
+
+	if err := RenderChatExport(io.Discard, export, "pdf"); !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("pdf export = %v, want ErrUnknownExportFormat", err)
+	}
+}
+
+func TestExportChats(t *testing.T) {
+	s := NewChatService(testDB(t))
+	starred, starredIDs := createChat(t, s.DB, models.Chat{ModelName: "mock/echo", Starred: true}, "Starred question", "Answer")
+	plain, plainIDs := createChat(t, s.DB, models.Chat{ModelName: "mock/echo"}, "Plain question", "Answer")
+	coveredFork, _ := createChat(t, s.DB, models.Chat{ModelName: "mock/echo", Starred: true, ParentID: &starred.ID, ForkMessageID: &starredIDs[1]}, "Starred question", "Other answer")
+	starredFork, _ := createChat(t, s.DB, models.Chat{ModelName: "mock/echo", Starred: true, ParentID: &plain.ID, ForkMessageID: &plainIDs[1]}, "Plain question", "Other answer")
+
+	tests := []struct {
+		name        string
+		starredOnly bool
+		withForks   bool
+		want        []uint // Chats with a file of their own, in order
+	}{
+		{name: "all root chats", want: []uint{starred.ID, plain.ID}},
+		{name: "all root chats with forks", withForks: true, want: []uint{starred.ID, plain.ID}},
+		{name: "starred chats", starredOnly: true, want: []uint{starred.ID, coveredFork.ID, starredFork.ID}},
+		{name: "starred chats with forks", starredOnly: true, withForks: true, want: []uint{starred.ID, starredFork.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := s.ExportChats(&buf, "md", tt.starredOnly, tt.withForks); err != nil {
+				t.Fatalf("ExportChats: %v", err)
+			}
+			archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("reading zip: %v", err)
+			}
+			var names, wantNames []string
+			for _, file := range archive.File {
+				names = append(names, file.Name)
+			}
+			for _, id := range tt.want {
+				wantNames = append(wantNames, fmt.Sprintf("chat-%d.md", id))
+			}
+			if fmt.Sprint(names) != fmt.Sprint(wantNames) {
+				t.Errorf("files = %q, want %q", names, wantNames)
+			}
+
+			// Forks are inside their chat's file only when asked for
+			file, err := archive.Open(fmt.Sprintf("chat-%d.md", tt.want[0]))
+			if err != nil {
+				t.Fatalf("opening export: %v", err)
+			}
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatalf("reading export: %v", err)
+			}
+			if hasFork := strings.Contains(string(data), "Other answer"); hasFork != tt.withForks {
+				t.Errorf("first file includes its fork: %v, want %v:\n%s", hasFork, tt.withForks, data)
+			}
+		})
+	}
+
+	if err := s.ExportChats(io.Discard, "pdf", false, false); !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("pdf export = %v, want ErrUnknownExportFormat", err)
+	}
+}
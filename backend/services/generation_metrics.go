@@ -0,0 +1,62 @@
+package services
+
+import "time"
+
+// generationMetrics records what the upstream reported about an answer and
+// how long it took, measured from the start of the attempt that answered.
+type generationMetrics struct {
+	provider         string
+	generationID     string
+	upstreamModel    string
+	upstreamProvider string
+	start            time.Time
+	firstToken       time.Duration
+	duration         time.Duration
+}
+
+// begin resets the metrics for a new attempt against provider.
+func (m *generationMetrics) begin(provider Provider) {
+	*m = generationMetrics{provider: provider.Name(), start: time.Now()}
+}
+
+// observe picks up the identifiers carried by a streamed chunk.
+func (m *generationMetrics) observe(chunk StreamResponse, hasContent bool) {
+	if chunk.ID != "" {
+		m.generationID = chunk.ID
+	}
+	if chunk.Model != "" {
+		m.upstreamModel = chunk.Model
+	}
+	if chunk.Provider != "" {
+		m.upstreamProvider = chunk.Provider
+	}
+	if hasContent && m.firstToken == 0 {
+		m.firstToken = time.Since(m.start)
+	}
+}
+
+// end stops the clock. An answer that was not streamed arrives all at once,
+// so its first token counts as arriving at the end.
+func (m *generationMetrics) end(streamed bool) {
+	m.duration = time.Since(m.start)
+	if !streamed {
+		m.firstToken = m.duration
+	}
+}
+
+// apply adds the metrics to a message update. Throughput only counts the
+// time spent producing tokens, after the first one arrived, so it is left
+// out when no token was seen arriving or all of them arrived at once.
+func (m *generationMetrics) apply(updates map[string]interface{}, completionTokens int) {
+	updates["generation_id"] = m.generationID
+	updates["provider_name"] = m.provider
+	updates["upstream_model"] = m.upstreamModel
+	updates["upstream_provider"] = m.upstreamProvider
+	updates["time_to_first_token"] = m.firstToken.Milliseconds()
+	updates["duration"] = m.duration.Milliseconds()
+
+	generating := m.duration - m.firstToken
+	if completionTokens > 0 && m.firstToken > 0 && generating > 0 {
+		updates["tokens_per_second"] = float64(completionTokens) / generating.Seconds()
+	}
+}
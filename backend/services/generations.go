@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrGenerationStopped is the cancellation cause of a generation stopped
+// through StopGeneration.
+var ErrGenerationStopped = errors.New("generation stopped")
+
+// ErrClientDisconnected is the cancellation cause of a generation nobody
+// reattached to within the detach timeout.
+var ErrClientDisconnected = errors.New("client disconnected")
+
+// ErrGenerationNotFound is returned when stopping or attaching to a message
+// that is not being generated.
+var ErrGenerationNotFound = errors.New("no active generation for message")
+
+// generation is an assistant answer produced in the background, independent
+// of the HTTP request that started it. Its events are buffered so clients
+// can detach, reattach and replay them.
+type generation struct {
+	messageID     uint
+	cancel        context.CancelCauseFunc
+	detachTimeout time.Duration
+	foreground    bool // Bound to the request that started it, which clients leaving must not cancel
+
+	mu          sync.Mutex
+	events      []bufferedEvent
+	finished    bool
+	changed     chan struct{} // Closed and replaced whenever events or finished change
+	subscribers int
+	detachTimer *time.Timer
+}
+
+func newGeneration(messageID uint, cancel context.CancelCauseFunc, detachTimeout time.Duration) *generation {
+	return &generation{
+		messageID:     messageID,
+		cancel:        cancel,
+		detachTimeout: detachTimeout,
+		changed:       make(chan struct{}),
+	}
+}
+
+// emit appends an event to the buffer and wakes up attached clients.
+func (g *generation) emit(event string, data interface{}) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Error marshaling %s event: %v\n", event, err)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.events = append(g.events, bufferedEvent{Seq: len(g.events) + 1, Type: event, Data: dataJSON})
+	g.broadcastLocked()
+}
+
+// finish marks the generation as complete; no events follow.
+func (g *generation) finish() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.finished = true
+	if g.detachTimer != nil {
+		g.detachTimer.Stop()
+		g.detachTimer = nil
+	}
+	g.broadcastLocked()
+}
+
+func (g *generation) broadcastLocked() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// attach writes every event with a sequence number above after to w, then
+// follows the generation until it finishes or ctx is done.
+func (g *generation) attach(ctx context.Context, after int, w io.Writer) error {
+	g.mu.Lock()
+	g.subscribers++
+	if g.detachTimer != nil {
+		g.detachTimer.Stop()
+		g.detachTimer = nil
+	}
+	g.mu.Unlock()
+	defer g.detach()
+
+	next := after
+	if next < 0 {
+		next = 0
+	}
+	for {
+		g.mu.Lock()
+		var pending []bufferedEvent
+		if next < len(g.events) {
+			pending = append(pending, g.events[next:]...)
+		}
+		finished := g.finished
+		changed := g.changed
+		g.mu.Unlock()
+
+		for _, event := range pending {
+			if err := writeBufferedEvent(w, event); err != nil {
+				return err
+			}
+			next = event.Seq
+		}
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// detach is called when a client goes away. Once the last one has left,
+// the generation is cancelled unless someone reattaches in time. A
+// foreground generation is left running for the request that waits on it.
+func (g *generation) detach() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribers--
+	if g.subscribers > 0 || g.finished || g.foreground {
+		return
+	}
+	if g.detachTimeout <= 0 {
+		g.cancel(ErrClientDisconnected)
+		return
+	}
+	g.detachTimer = time.AfterFunc(g.detachTimeout, func() {
+		g.mu.Lock()
+		idle := g.subscribers == 0
+		g.mu.Unlock()
+		if idle {
+			g.cancel(ErrClientDisconnected)
+		}
+	})
+}
+
+// generationRegistry tracks generations by assistant message ID so they can
+// be stopped or reattached to from another request.
+type generationRegistry struct {
+	mu          sync.Mutex
+	generations map[uint]*generation
+}
+
+func newGenerationRegistry() *generationRegistry {
+	return &generationRegistry{generations: make(map[uint]*generation)}
+}
+
+func (r *generationRegistry) add(g *generation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generations[g.messageID] = g
+}
+
+func (r *generationRegistry) get(messageID uint) (*generation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.generations[messageID]
+	return g, ok
+}
+
+// alias makes a generation reachable through another assistant message,
+// one it created after running tools.
+func (r *generationRegistry) alias(messageID uint, g *generation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generations[messageID] = g
+}
+
+// removeAfter forgets a finished generation, under every message ID it is
+// registered with, once clients have had time to replay it.
+func (r *generationRegistry) removeAfter(g *generation, retention time.Duration) {
+	time.AfterFunc(retention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for id, registered := range r.generations {
+			if registered == g {
+				delete(r.generations, id)
+			}
+		}
+	})
+}
+
+func (r *generationRegistry) stop(messageID uint) bool {
+	g, ok := r.get(messageID)
+	if !ok {
+		return false
+	}
+	g.mu.Lock()
+	finished := g.finished
+	g.mu.Unlock()
+	if finished {
+		return false
+	}
+	g.cancel(ErrGenerationStopped)
+	return true
+}
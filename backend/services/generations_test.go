@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+// sseRecorder is a ResponseWriter that can be read while a generation is
+// still writing to it.
+type sseRecorder struct {
+	mu     sync.Mutex
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newSSERecorder() *sseRecorder {
+	return &sseRecorder{header: make(http.Header)}
+}
+
+func (r *sseRecorder) Header() http.Header { return r.header }
+
+func (r *sseRecorder) WriteHeader(int) {}
+
+func (r *sseRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.body.Write(p)
+}
+
+type sseEvent struct {
+	seq  int
+	typ  string
+	data map[string]interface{}
+}
+
+// events parses the events written so far.
+func (r *sseRecorder) events(t *testing.T) []sseEvent {
+	t.Helper()
+	r.mu.Lock()
+	body := r.body.String()
+	r.mu.Unlock()
+
+	var events []sseEvent
+	var event sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			event.seq, _ = strconv.Atoi(value)
+		case "event":
+			event.typ = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &event.data); err != nil {
+				t.Fatalf("event %d data %q: %v", event.seq, value, err)
+			}
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	return events
+}
+
+// waitFor polls the recorded events until ok accepts them.
+func (r *sseRecorder) waitFor(t *testing.T, what string, ok func([]sseEvent) bool) []sseEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := r.events(t)
+		if ok(events) {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s; got %+v", what, events)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func countEvents(events []sseEvent, typ string) int {
+	n := 0
+	for _, event := range events {
+		if event.typ == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// startChat runs Chat in the background and returns its recorder and a
+// channel receiving its result.
+func startChat(ctx context.Context, s *ChatService, req ChatRequest) (*sseRecorder, <-chan error) {
+	w := newSSERecorder()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Chat(ctx, req, 0, w)
+	}()
+	return w, done
+}
+
+func TestStreamMessageReplaysAfterReattaching(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{ChunkDelay: 20 * time.Millisecond})
+	s.DetachTimeout = 5 * time.Second
+
+	ctx, leave := context.WithCancel(context.Background())
+	first, chatDone := startChat(ctx, s, ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: "one two three four five six seven eight"}},
+	})
+	events := first.waitFor(t, "two deltas", func(events []sseEvent) bool {
+		return countEvents(events, EventDelta) >= 2
+	})
+	leave()
+	if err := <-chatDone; err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	events = first.events(t)
+	seen := events[len(events)-1].seq
+	messageID := uint(events[1].data["messageId"].(float64))
+
+	// Deltas keep being buffered while nobody is attached
+	time.Sleep(50 * time.Millisecond)
+	second := newSSERecorder()
+	if err := s.StreamMessage(context.Background(), messageID, seen, second); err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+	replayed := second.events(t)
+	if len(replayed) == 0 {
+		t.Fatal("nothing replayed")
+	}
+	for i, event := range replayed {
+		if event.seq != seen+1+i {
+			t.Fatalf("event %d has seq %d, want %d", i, event.seq, seen+1+i)
+		}
+	}
+	if last := replayed[len(replayed)-1]; last.typ != EventDone || last.data["finishReason"] != "stop" {
+		t.Errorf("last event = %+v, want done", last)
+	}
+
+	var answer models.Message
+	if err := db.First(&answer, messageID).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.Status != models.MessageStatusComplete || answer.Content != "one two three four five six seven eight" {
+		t.Errorf("answer status, content = %q, %q", answer.Status, answer.Content)
+	}
+}
+
+func TestGenerationCancelledAfterDetachTimeout(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{ChunkDelay: 50 * time.Millisecond})
+	s.DetachTimeout = 20 * time.Millisecond
+
+	ctx, leave := context.WithCancel(context.Background())
+	w, chatDone := startChat(ctx, s, ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: strings.Repeat("word ", 40)}},
+	})
+	events := w.waitFor(t, "a delta", func(events []sseEvent) bool {
+		return countEvents(events, EventDelta) >= 1
+	})
+	messageID := uint(events[1].data["messageId"].(float64))
+	leave()
+	if err := <-chatDone; err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var answer models.Message
+	for {
+		if err := db.First(&answer, messageID).Error; err != nil {
+			t.Fatalf("loading answer: %v", err)
+		}
+		if answer.Status != models.MessageStatusStreaming || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if answer.Status != models.MessageStatusCancelled || answer.Content == "" {
+		t.Errorf("answer status, content = %q, %q, want the partial answer cancelled", answer.Status, answer.Content)
+	}
+
+	// The events stay available for replay after the generation ended
+	replay := newSSERecorder()
+	if err := s.StreamMessage(context.Background(), messageID, 0, replay); err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+	replayed := replay.events(t)
+	if last := replayed[len(replayed)-1]; last.typ != EventDone || last.data["finishReason"] != models.MessageStatusCancelled {
+		t.Errorf("last event = %+v, want done as cancelled", last)
+	}
+}
+
+func TestGenerationSurvivesReattachBeforeTimeout(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{ChunkDelay: 20 * time.Millisecond})
+	s.DetachTimeout = 200 * time.Millisecond
+
+	ctx, leave := context.WithCancel(context.Background())
+	w, chatDone := startChat(ctx, s, ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: strings.Repeat("word ", 20)}},
+	})
+	events := w.waitFor(t, "a delta", func(events []sseEvent) bool {
+		return countEvents(events, EventDelta) >= 1
+	})
+	messageID := uint(events[1].data["messageId"].(float64))
+	leave()
+	if err := <-chatDone; err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+
+	// The answer takes longer than the timeout, which reattaching stops
+	replay := newSSERecorder()
+	if err := s.StreamMessage(context.Background(), messageID, 0, replay); err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+	replayed := replay.events(t)
+	if last := replayed[len(replayed)-1]; last.typ != EventDone || last.data["finishReason"] != "stop" {
+		t.Errorf("last event = %+v, want done", last)
+	}
+}
+
+func TestStopGenerationThroughToolTurnMessage(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{ChunkDelay: 100 * time.Millisecond})
+
+	w, chatDone := startChat(context.Background(), s, ChatRequest{
+		Model:    "mock/tools",
+		Tools:    []string{"calculator"},
+		Messages: []Message{{Role: "user", Content: `calculator {"expression":"1+1"}`}},
+	})
+	var assistants []uint
+	w.waitFor(t, "the assistant message after the tool", func(events []sseEvent) bool {
+		assistants = assistants[:0]
+		for _, event := range events {
+			if event.typ == EventMessageCreated && event.data["role"] == "assistant" {
+				assistants = append(assistants, uint(event.data["messageId"].(float64)))
+			}
+		}
+		return len(assistants) == 2
+	})
+
+	if err := s.StopGeneration(assistants[1]); err != nil {
+		t.Fatalf("StopGeneration: %v", err)
+	}
+	if err := <-chatDone; err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	events := w.events(t)
+	last := events[len(events)-1]
+	if last.typ != EventDone || last.data["finishReason"] != models.MessageStatusCancelled || uint(last.data["messageId"].(float64)) != assistants[1] {
+		t.Errorf("last event = %+v, want message %d done as cancelled", last, assistants[1])
+	}
+	var answer models.Message
+	if err := db.First(&answer, assistants[1]).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.Status != models.MessageStatusCancelled {
+		t.Errorf("status = %q, want cancelled", answer.Status)
+	}
+	if err := s.StopGeneration(assistants[1]); err != ErrGenerationNotFound {
+		t.Errorf("stopping again = %v, want ErrGenerationNotFound", err)
+	}
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidImport is returned for uploads that are not an export Import
+// can read.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportResult reports what became of one imported conversation.
+type ImportResult struct {
+	SourceID string `json:"sourceId"`
+	Title    string `json:"title,omitempty"`
+	ChatID   uint   `json:"chatId"`
+	Skipped  bool   `json:"skipped"` // Imported before; ChatID is the earlier import
+}
+
+// Import saves the conversations of a ChatGPT, Claude or ai-playground
+// export, given as JSON or as a zip holding it. Branches become forks.
+// Conversations and their forks are recognised by their source ID, and our
+// own chats also by ID and creation time, so importing the same export
+// again, or into the database it came from, skips them.
+func (s *ChatService) Import(data []byte) ([]ImportResult, error) {
+	conversations, err := parseImport(data, s.MaxImportBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ImportResult, 0, len(conversations))
+	for _, conversation := range conversations {
+		result := ImportResult{SourceID: importSourceID(&conversation), Title: conversation.Title}
+
+		existing, err := findImportedChat(s.DB, &conversation)
+		if err == nil {
+			result.ChatID = existing.ID
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return results, fmt.Errorf("error looking up %s: %v", result.SourceID, err)
+		}
+
+		// Timestamps come from the export, so the hooks setting them are skipped
+		if err := s.DB.Transaction(func(tx *gorm.DB) error {
+			result.ChatID, err = saveImportedChat(tx.Session(&gorm.Session{SkipHooks: true}), &conversation, nil, nil)
+			return err
+		}); err != nil {
+			return results, fmt.Errorf("error importing %s: %v", result.SourceID, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// importSourceID returns the source ID of an imported chat. Our own exports
+// of chats that were not imported themselves are known by ID and creation
+// time.
+func importSourceID(chat *ChatExport) string {
+	if chat.SourceID != nil {
+		return *chat.SourceID
+	}
+	return fmt.Sprintf("ai-playground:%d:%s", chat.ID, chat.CreatedAt)
+}
+
+// findImportedChat looks up the chat an export was imported as before. An
+// export of a chat that was not imported itself may also come from this
+// database, so a chat with its ID and creation time matches as well.
+func findImportedChat(tx *gorm.DB, export *ChatExport) (models.Chat, error) {
+	var existing models.Chat
+	err := tx.Unscoped().Select("id", "parent_id").Where("source_id = ?", importSourceID(export)).First(&existing).Error
+	if export.SourceID != nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return existing, err
+	}
+
+	// Creation times are compared as times, as the export may have written
+	// them in another zone
+	exportedAt, parseErr := time.Parse(time.RFC3339, export.CreatedAt)
+	if parseErr != nil {
+		return existing, err
+	}
+	if err := tx.Unscoped().Select("id", "parent_id", "created_at").Where("id = ? AND source_id IS NULL", export.ID).First(&existing).Error; err != nil {
+		return models.Chat{}, err
+	}
+	if createdAt, err := time.Parse(time.RFC3339, existing.CreatedAt); err != nil || !createdAt.Equal(exportedAt) {
+		return models.Chat{}, gorm.ErrRecordNotFound
+	}
+	return existing, nil
+}
+
+// saveImportedChat creates a chat, its messages and its forks. parentID and
+// parentMessageIDs, from the export's message IDs to the saved ones, are
+// set for forks.
+func saveImportedChat(tx *gorm.DB, export *ChatExport, parentID *uint, parentMessageIDs map[uint]uint) (uint, error) {
+	createdAt := export.CreatedAt
+	if createdAt == "" {
+		createdAt = time.Now().Format(time.RFC3339)
+	}
+	updatedAt := export.UpdatedAt
+	if updatedAt == "" {
+		updatedAt = createdAt
+	}
+	sourceID := importSourceID(export)
+	chat := models.Chat{
+		BaseModel:      models.BaseModel{CreatedAt: createdAt, UpdatedAt: updatedAt},
+		ModelName:      export.ModelName,
+		Title:          export.Title,
+		ProviderName:   export.ProviderName,
+		FallbackModels: export.FallbackModels,
+		SystemPrompt:   export.SystemPrompt,
+		DefaultParams:  export.DefaultParams,
+		Tools:          export.Tools,
+		Starred:        export.Starred,
+		ParentID:       parentID,
+		SourceID:       &sourceID,
+	}
+	if export.ForkMessageID != nil {
+		if id, ok := parentMessageIDs[*export.ForkMessageID]; ok {
+			chat.ForkMessageID = &id
+		}
+	}
+	if err := tx.Omit(clause.Associations).Create(&chat).Error; err != nil {
+		return 0, fmt.Errorf("error saving chat: %v", err)
+	}
+
+	// History is read in creation order, so timestamps must not go back
+	messageIDs := make(map[uint]uint, len(export.Messages))
+	callers := make(map[string]uint)
+	previous := ""
+	for _, m := range export.Messages {
+		messageCreatedAt := m.CreatedAt
+		if messageCreatedAt < previous {
+			messageCreatedAt = previous
+		}
+		if messageCreatedAt == "" {
+			messageCreatedAt = createdAt
+		}
+		previous = messageCreatedAt
+
+		status := m.Status
+		if status == "" {
+			status = models.MessageStatusComplete
+		} else if status == models.MessageStatusStreaming {
+			status = models.MessageStatusCancelled
+		}
+		message := models.Message{
+			BaseModel:        models.BaseModel{CreatedAt: messageCreatedAt, UpdatedAt: messageCreatedAt},
+			ChatID:           chat.ID,
+			Role:             m.Role,
+			Content:          m.Content,
+			Reasoning:        m.Reasoning,
+			ModelName:        m.ModelName,
+			Starred:          m.Starred,
+			PromptTokens:     m.PromptTokens,
+			CompletionTokens: m.CompletionTokens,
+			ReasoningTokens:  m.ReasoningTokens,
+			TotalTokens:      m.TotalTokens,
+			Status:           status,
+			FinishReason:     m.FinishReason,
+			Error:            m.Error,
+			ToolCalls:        m.ToolCalls,
+			ToolCallID:       m.ToolCallID,
+			ToolName:         m.ToolName,
+		}
+		if id, ok := callers[m.ToolCallID]; ok && m.ToolCallID != "" {
+			message.CallerMessageID = &id
+		}
+		if err := tx.Omit(clause.Associations).Create(&message).Error; err != nil {
+			return 0, fmt.Errorf("error saving message: %v", err)
+		}
+		messageIDs[m.ID] = message.ID
+		for _, call := range m.ToolCalls {
+			callers[call.ID] = message.ID
+		}
+	}
+
+	for i := range export.Forks {
+		if err := saveImportedFork(tx, &export.Forks[i], chat.ID, messageIDs); err != nil {
+			return 0, err
+		}
+	}
+	return chat.ID, nil
+}
+
+// saveImportedFork saves a fork of the chat parentID unless findImportedChat
+// finds it, such as a fork exported and imported on its own before. Such a
+// chat is reattached under the parent when it has none; one that is already
+// a fork elsewhere is left where it is. Either way its own forks are not
+// imported again.
+func saveImportedFork(tx *gorm.DB, fork *ChatExport, parentID uint, parentMessageIDs map[uint]uint) error {
+	sourceID := importSourceID(fork)
+	existing, err := findImportedChat(tx, fork)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_, err = saveImportedChat(tx, fork, &parentID, parentMessageIDs)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error looking up fork %s: %v", sourceID, err)
+	}
+	if existing.ParentID != nil {
+		return nil
+	}
+
+	updates := map[string]interface{}{"parent_id": parentID}
+	if fork.ForkMessageID != nil {
+		if id, ok := parentMessageIDs[*fork.ForkMessageID]; ok {
+			updates["fork_message_id"] = id
+		}
+	}
+	if err := tx.Unscoped().Model(&models.Chat{}).Where("id = ?", existing.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("error reattaching fork %s: %v", sourceID, err)
+	}
+	return nil
+}
+
+// parseImport reads the conversations of an upload: a JSON export, or a zip
+// holding ChatGPT's or Claude's conversations.json or our chat-*.json files.
+// The files read from a zip may decompress to at most maxBytes in total.
+func parseImport(data []byte, maxBytes int64) ([]ChatExport, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseImportJSON(data)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	var conversations []ChatExport
+	found := false
+	for _, file := range archive.File {
+		name := path.Base(file.Name)
+		if name != "conversations.json" && !(strings.HasPrefix(name, "chat-") && strings.HasSuffix(name, ".json")) {
+			continue
+		}
+		found = true
+		r, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		contents, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		if int64(len(contents)) > maxBytes {
+			return nil, fmt.Errorf("%w: the zip's conversations are larger than %d bytes", ErrInvalidImport, maxBytes)
+		}
+		maxBytes -= int64(len(contents))
+		parsed, err := parseImportJSON(contents)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		conversations = append(conversations, parsed...)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: the zip holds no conversations.json or chat-*.json", ErrInvalidImport)
+	}
+	return conversations, nil
+}
+
+// parseImportJSON reads a JSON export: one conversation or an array of
+// them, each recognised by its shape.
+func parseImportJSON(data []byte) ([]ChatExport, error) {
+	data = bytes.TrimSpace(data)
+	items := []json.RawMessage{data}
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+	}
+
+	var conversations []ChatExport
+	for i, item := range items {
+		var probe struct {
+			Format       string          `json:"format"`
+			Mapping      json.RawMessage `json:"mapping"`
+			ChatMessages json.RawMessage `json:"chat_messages"`
+		}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, fmt.Errorf("%w: conversation %d: %v", ErrInvalidImport, i, err)
+		}
+
+		var conversation ChatExport
+		var err error
+		switch {
+		case probe.Format == ExportFormat:
+			conversation, err = parseOwnExport(item)
+		case probe.Mapping != nil:
+			conversation, err = parseChatGPTConversation(item)
+		case probe.ChatMessages != nil:
+			conversation, err = parseClaudeConversation(item)
+		default:
+			err = errors.New("not a ChatGPT, Claude or ai-playground export")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: conversation %d: %v", ErrInvalidImport, i, err)
+		}
+		if len(conversation.Messages) > 0 {
+			conversations = append(conversations, conversation)
+		}
+	}
+	return conversations, nil
+}
+
+// parseOwnExport reads a chat written by RenderChatExport.
+func parseOwnExport(data []byte) (ChatExport, error) {
+	var chat ChatExport
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return ChatExport{}, err
+	}
+	if chat.Version > ExportVersion {
+		return ChatExport{}, fmt.Errorf("export version %d is newer than this build reads", chat.Version)
+	}
+	return chat, nil
+}
+
+// importTime formats a source timestamp the way timestamps are stored.
+func importTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// treeMessage is a message of a conversation stored as a tree, as ChatGPT
+// and Claude exports are: editing a message or regenerating an answer adds
+// a sibling instead of replacing it.
+type treeMessage struct {
+	key      string
+	message  MessageExport
+	children []*treeMessage
+}
+
+// messageTree turns a tree of messages into a chat and its forks. The
+// branch through current is the chat; every other branch is a fork of the
+// chat it leaves, starting with copies of the messages before it like forks
+// made in the playground.
+type messageTree struct {
+	sourceID string
+	current  map[string]bool
+	nextID   uint
+	visited  map[*treeMessage]bool
+}
+
+// chat builds the chat for a conversation starting at roots.
+func (t *messageTree) chat(roots []*treeMessage) ChatExport {
+	chat := t.branch(nil, roots)
+	chat.SourceID = &t.sourceID
+	return chat
+}
+
+// branch builds a chat of copies of prefix followed by one of choices and
+// its replies, picking the current message at every step and the latest
+// where none is current. Each message is used once, so a tree whose parent
+// links form a cycle still ends.
+func (t *messageTree) branch(prefix []MessageExport, choices []*treeMessage) ChatExport {
+	if t.visited == nil {
+		t.visited = make(map[*treeMessage]bool)
+	}
+	var chat ChatExport
+	for _, msg := range prefix {
+		chat.Messages = append(chat.Messages, t.number(msg))
+	}
+	for {
+		var unvisited []*treeMessage
+		for _, choice := range choices {
+			if !t.visited[choice] {
+				unvisited = append(unvisited, choice)
+			}
+		}
+		if len(unvisited) == 0 {
+			break
+		}
+		choices = unvisited
+
+		next := choices[len(choices)-1]
+		for _, choice := range choices {
+			if t.current[choice.key] {
+				next = choice
+			}
+		}
+		t.visited[next] = true
+		msg := t.number(next.message)
+		for _, choice := range choices {
+			// An earlier fork may have reached it through a cycle
+			if choice == next || t.visited[choice] {
+				continue
+			}
+			fork := t.branch(chat.Messages, []*treeMessage{choice})
+			sourceID := t.sourceID + ":" + choice.key
+			fork.SourceID = &sourceID
+			fork.ForkMessageID = &msg.ID
+			chat.Forks = append(chat.Forks, fork)
+		}
+		chat.Messages = append(chat.Messages, msg)
+		choices = next.children
+	}
+
+	if len(chat.Messages) > len(prefix) {
+		chat.CreatedAt = chat.Messages[len(prefix)].CreatedAt
+		chat.UpdatedAt = chat.Messages[len(chat.Messages)-1].CreatedAt
+	}
+	for _, msg := range chat.Messages {
+		if msg.Role == "assistant" && msg.ModelName != "" {
+			chat.ModelName = msg.ModelName
+		}
+	}
+	return chat
+}
+
+// number gives a message an ID unique within the conversation, which forks
+// refer to until the chats are saved.
+func (t *messageTree) number(msg MessageExport) MessageExport {
+	t.nextID++
+	msg.ID = t.nextID
+	return msg
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chatGPTConversation is a conversation of a ChatGPT conversations.json
+// export. Mapping holds every message as a tree node; CurrentNode is the
+// last message of the branch shown in ChatGPT.
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	UpdateTime     float64                `json:"update_time"`
+	CurrentNode    string                 `json:"current_node"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// chatGPTTime converts ChatGPT's fractional Unix timestamps.
+func chatGPTTime(seconds float64) string {
+	if seconds == 0 {
+		return ""
+	}
+	whole, fraction := math.Modf(seconds)
+	return importTime(time.Unix(int64(whole), int64(fraction*1e9)))
+}
+
+// text returns the visible text of a message. Images and other files are
+// replaced by a note.
+func (m *chatGPTMessage) text() string {
+	switch m.Content.ContentType {
+	case "text", "multimodal_text":
+		var parts []string
+		for _, raw := range m.Content.Parts {
+			var text string
+			if err := json.Unmarshal(raw, &text); err == nil {
+				if text != "" {
+					parts = append(parts, text)
+				}
+				continue
+			}
+			var asset struct {
+				ContentType string `json:"content_type"`
+			}
+			if err := json.Unmarshal(raw, &asset); err == nil && strings.HasPrefix(asset.ContentType, "image") {
+				parts = append(parts, "[Image]")
+			} else {
+				parts = append(parts, "[Attachment]")
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	case "code":
+		return m.Content.Text
+	default:
+		return ""
+	}
+}
+
+// message converts a ChatGPT message, reporting false for messages the
+// ChatGPT interface does not show: system prompts, tool traffic, hidden
+// context and empty messages.
+func (m *chatGPTMessage) message() (MessageExport, bool) {
+	role := m.Author.Role
+	if (role != "user" && role != "assistant") || m.Metadata.Hidden {
+		return MessageExport{}, false
+	}
+	if role == "assistant" && m.Recipient != "" && m.Recipient != "all" {
+		return MessageExport{}, false
+	}
+	text := m.text()
+	if text == "" {
+		return MessageExport{}, false
+	}
+	msg := MessageExport{Role: role, Content: text, CreatedAt: chatGPTTime(m.CreateTime)}
+	if role == "assistant" && m.Metadata.ModelSlug != "" {
+		msg.ModelName = "openai/" + m.Metadata.ModelSlug
+	}
+	return msg, true
+}
+
+func parseChatGPTConversation(data []byte) (ChatExport, error) {
+	var c chatGPTConversation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return ChatExport{}, err
+	}
+	id := c.ConversationID
+	if id == "" {
+		id = c.ID
+	}
+	if id == "" {
+		return ChatExport{}, errors.New("ChatGPT conversation without an id")
+	}
+
+	// Messages that are not shown are left out, their replies attached to
+	// the closest shown message above them
+	var roots []*treeMessage
+	visited := make(map[string]bool)
+	var walk func(key string, parent *treeMessage)
+	walk = func(key string, parent *treeMessage) {
+		node, ok := c.Mapping[key]
+		if !ok || visited[key] {
+			return
+		}
+		visited[key] = true
+		if node.Message != nil {
+			if msg, ok := node.Message.message(); ok {
+				tm := &treeMessage{key: key, message: msg}
+				if parent == nil {
+					roots = append(roots, tm)
+				} else {
+					parent.children = append(parent.children, tm)
+				}
+				parent = tm
+			}
+		}
+		for _, child := range node.Children {
+			walk(child, parent)
+		}
+	}
+	var rootKeys []string
+	for key, node := range c.Mapping {
+		if _, ok := c.Mapping[node.Parent]; !ok {
+			rootKeys = append(rootKeys, key)
+		}
+	}
+	sort.Strings(rootKeys)
+	for _, key := range rootKeys {
+		walk(key, nil)
+	}
+
+	tree := messageTree{sourceID: "chatgpt:" + id, current: make(map[string]bool)}
+	for key := c.CurrentNode; key != "" && !tree.current[key]; key = c.Mapping[key].Parent {
+		tree.current[key] = true
+	}
+	chat := tree.chat(roots)
+	if createdAt := chatGPTTime(c.CreateTime); createdAt != "" {
+		chat.CreatedAt = createdAt
+	}
+	if updatedAt := chatGPTTime(c.UpdateTime); updatedAt != "" {
+		chat.UpdatedAt = updatedAt
+	}
+	chat.Title = c.Title
+	return chat, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// claudeConversation is a conversation of a Claude conversations.json
+// export. Messages name their parent when the conversation has branches;
+// older exports list a single branch in order.
+type claudeConversation struct {
+	UUID         string          `json:"uuid"`
+	Name         string          `json:"name"`
+	CreatedAt    string          `json:"created_at"`
+	UpdatedAt    string          `json:"updated_at"`
+	CurrentLeaf  string          `json:"current_leaf_message_uuid"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	UUID       string `json:"uuid"`
+	ParentUUID string `json:"parent_message_uuid"`
+	Sender     string `json:"sender"`
+	Text       string `json:"text"`
+	CreatedAt  string `json:"created_at"`
+	Content    []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Attachments []struct {
+		FileName         string `json:"file_name"`
+		ExtractedContent string `json:"extracted_content"`
+	} `json:"attachments"`
+}
+
+// claudeTime converts Claude's RFC 3339 timestamps.
+func claudeTime(value string) string {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return ""
+	}
+	return importTime(t)
+}
+
+// message converts a Claude message. Text blocks are joined, and the text
+// Claude extracted from attached files is appended the way text attachments
+// are sent here. It reports false for messages without any text.
+func (m *claudeMessage) message() (MessageExport, bool) {
+	var parts []string
+	for _, block := range m.Content {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	if len(parts) == 0 && m.Text != "" {
+		parts = append(parts, m.Text)
+	}
+	for _, a := range m.Attachments {
+		if a.ExtractedContent != "" {
+			parts = append(parts, fmt.Sprintf("Attached file %s:\n\n%s", a.FileName, a.ExtractedContent))
+		}
+	}
+
+	if len(parts) == 0 {
+		return MessageExport{}, false
+	}
+
+	role := m.Sender
+	if role == "human" {
+		role = "user"
+	}
+	return MessageExport{Role: role, Content: strings.Join(parts, "\n\n"), CreatedAt: claudeTime(m.CreatedAt)}, true
+}
+
+func parseClaudeConversation(data []byte) (ChatExport, error) {
+	var c claudeConversation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return ChatExport{}, err
+	}
+	if c.UUID == "" {
+		return ChatExport{}, errors.New("Claude conversation without a uuid")
+	}
+
+	nodes := make(map[string]*treeMessage, len(c.ChatMessages))
+	parents := make(map[string]string, len(c.ChatMessages))
+	branched := false
+	for _, m := range c.ChatMessages {
+		if _, ok := parents[m.UUID]; ok {
+			return ChatExport{}, fmt.Errorf("duplicate message uuid %q", m.UUID)
+		}
+		if msg, ok := m.message(); ok {
+			nodes[m.UUID] = &treeMessage{key: m.UUID, message: msg}
+		}
+		parents[m.UUID] = m.ParentUUID
+		if m.ParentUUID != "" {
+			branched = true
+		}
+	}
+
+	// Without parents, each message answers the one before it. Messages
+	// without text are left out, their replies attached to the closest
+	// message above them.
+	if !branched {
+		for i := 1; i < len(c.ChatMessages); i++ {
+			parents[c.ChatMessages[i].UUID] = c.ChatMessages[i-1].UUID
+		}
+	}
+	var roots []*treeMessage
+	for _, m := range c.ChatMessages {
+		node, ok := nodes[m.UUID]
+		if !ok {
+			continue
+		}
+		seen := map[string]bool{m.UUID: true}
+		parentKey := parents[m.UUID]
+		for parentKey != "" && nodes[parentKey] == nil && !seen[parentKey] {
+			seen[parentKey] = true
+			parentKey = parents[parentKey]
+		}
+		if parent, ok := nodes[parentKey]; ok && !seen[parentKey] {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	tree := messageTree{sourceID: "claude:" + c.UUID, current: make(map[string]bool)}
+	leaf := c.CurrentLeaf
+	if leaf == "" && len(c.ChatMessages) > 0 {
+		leaf = c.ChatMessages[len(c.ChatMessages)-1].UUID
+	}
+	for key := leaf; key != "" && !tree.current[key]; key = parents[key] {
+		tree.current[key] = true
+	}
+	chat := tree.chat(roots)
+	if createdAt := claudeTime(c.CreatedAt); createdAt != "" {
+		chat.CreatedAt = createdAt
+	}
+	if updatedAt := claudeTime(c.UpdatedAt); updatedAt != "" {
+		chat.UpdatedAt = updatedAt
+	}
+	chat.Title = c.Name
+	return chat, nil
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+func TestImportOwnExportIntoSameDatabase(t *testing.T) {
+	db := testDB(t)
+	s := NewChatService(db, &MockProvider{})
+
+	result, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:    "mock/echo",
+		Messages: []Message{{Role: "user", Content: "hello"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("ChatSync: %v", err)
+	}
+	fork := models.Chat{ModelName: "mock/echo", ParentID: &result.ChatID, ForkMessageID: &result.UserMessageID}
+	if err := db.Create(&fork).Error; err != nil {
+		t.Fatalf("creating fork: %v", err)
+	}
+	if err := db.Create(&models.Message{ChatID: fork.ID, Role: "user", Content: "hello", Status: models.MessageStatusComplete}).Error; err != nil {
+		t.Fatalf("creating fork message: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		chatID uint
+		zone   *time.Location // Where the export's creation time is written, if moved
+	}{
+		{"chat with its forks", result.ChatID, nil},
+		{"fork on its own", fork.ID, nil},
+		{"creation time in another zone", result.ChatID, time.FixedZone("UTC+5", 5*60*60)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			export, err := s.LoadChatExport(tt.chatID, true)
+			if err != nil {
+				t.Fatalf("LoadChatExport: %v", err)
+			}
+			if tt.zone != nil {
+				createdAt, err := time.Parse(time.RFC3339, export.CreatedAt)
+				if err != nil {
+					t.Fatalf("parsing creation time: %v", err)
+				}
+				export.CreatedAt = createdAt.In(tt.zone).Format(time.RFC3339)
+			}
+			var buf bytes.Buffer
+			if err := RenderChatExport(&buf, export, "json"); err != nil {
+				t.Fatalf("RenderChatExport: %v", err)
+			}
+
+			results, err := s.Import(buf.Bytes())
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if len(results) != 1 || !results[0].Skipped || results[0].ChatID != tt.chatID {
+				t.Errorf("results = %+v, want chat %d skipped", results, tt.chatID)
+			}
+			var chats int64
+			if err := db.Model(&models.Chat{}).Count(&chats).Error; err != nil {
+				t.Fatalf("counting chats: %v", err)
+			}
+			if chats != 2 {
+				t.Errorf("%d chats after importing, want the 2 exported", chats)
+			}
+		})
+	}
+}
+
+const chatGPTFixture = `[{
+	"conversation_id": "conv-1",
+	"title": "Greetings",
+	"create_time": 1700000000,
+	"update_time": 1700000030,
+	"current_node": "u2",
+	"mapping": {
+		"root": {"message": null, "parent": "", "children": ["u1"]},
+		"u1": {"parent": "root", "children": ["a1", "a2"], "message": {"author": {"role": "user"}, "create_time": 1700000000, "content": {"content_type": "text", "parts": ["Hi"]}}},
+		"a1": {"parent": "u1", "children": ["u2"], "message": {"author": {"role": "assistant"}, "create_time": 1700000010, "content": {"content_type": "text", "parts": ["First answer"]}, "metadata": {"model_slug": "gpt-4o"}}},
+		"a2": {"parent": "u1", "children": [], "message": {"author": {"role": "assistant"}, "create_time": 1700000020, "content": {"content_type": "text", "parts": ["Regenerated answer"]}, "metadata": {"model_slug": "gpt-4o"}}},
+		"u2": {"parent": "a1", "children": [], "message": {"author": {"role": "user"}, "create_time": 1700000005, "content": {"content_type": "text", "parts": ["Thanks"]}}}
+	}
+}]`
+
+const claudeFixture = `[{
+	"uuid": "conv-2",
+	"name": "Hello",
+	"created_at": "2024-01-01T10:00:00Z",
+	"updated_at": "2024-01-01T10:00:30Z",
+	"current_leaf_message_uuid": "a2",
+	"chat_messages": [
+		{"uuid": "u1", "parent_message_uuid": "00000000-0000-4000-8000-000000000000", "sender": "human", "text": "Hello", "created_at": "2024-01-01T10:00:00Z"},
+		{"uuid": "a1", "parent_message_uuid": "u1", "sender": "assistant", "text": "Old reply", "created_at": "2024-01-01T10:00:10Z"},
+		{"uuid": "u2", "parent_message_uuid": "a1", "sender": "human", "text": "Follow-up", "created_at": "2024-01-01T10:00:15Z"},
+		{"uuid": "a2", "parent_message_uuid": "u1", "sender": "assistant", "text": "New reply", "created_at": "2024-01-01T10:00:20Z"}
+	]
+}]`
+
+// importedMessages loads a chat's messages in history order.
+func importedMessages(t *testing.T, s *ChatService, chatID uint) []models.Message {
+	t.Helper()
+	var messages []models.Message
+	if err := s.DB.Where("chat_id = ?", chatID).Order("created_at ASC, id ASC").Find(&messages).Error; err != nil {
+		t.Fatalf("loading messages of chat %d: %v", chatID, err)
+	}
+	return messages
+}
+
+// contents lists the role and content of each message.
+func contents(messages []models.Message) []string {
+	var got []string
+	for _, m := range messages {
+		got = append(got, m.Role+": "+m.Content)
+	}
+	return got
+}
+
+func TestImportTreeBecomesChatAndFork(t *testing.T) {
+	tests := []struct {
+		name         string
+		export       string
+		sourceID     string
+		title        string
+		chat         []string
+		fork         []string
+		forkSourceID string
+		forkMessage  string // Content of the chat's message the fork replaces
+	}{
+		{
+			name:         "ChatGPT mapping",
+			export:       chatGPTFixture,
+			sourceID:     "chatgpt:conv-1",
+			title:        "Greetings",
+			chat:         []string{"user: Hi", "assistant: First answer", "user: Thanks"},
+			fork:         []string{"user: Hi", "assistant: Regenerated answer"},
+			forkSourceID: "chatgpt:conv-1:a2",
+			forkMessage:  "First answer",
+		},
+		{
+			name:         "Claude parent_message_uuid",
+			export:       claudeFixture,
+			sourceID:     "claude:conv-2",
+			title:        "Hello",
+			chat:         []string{"user: Hello", "assistant: New reply"},
+			fork:         []string{"user: Hello", "assistant: Old reply", "user: Follow-up"},
+			forkSourceID: "claude:conv-2:a1",
+			forkMessage:  "New reply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewChatService(testDB(t))
+
+			results, err := s.Import([]byte(tt.export))
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if len(results) != 1 || results[0].Skipped || results[0].SourceID != tt.sourceID {
+				t.Fatalf("results = %+v, want %s imported", results, tt.sourceID)
+			}
+			chatID := results[0].ChatID
+
+			var chats []models.Chat
+			if err := s.DB.Order("id ASC").Find(&chats).Error; err != nil {
+				t.Fatalf("loading chats: %v", err)
+			}
+			if len(chats) != 2 {
+				t.Fatalf("imported %d chats, want the chat and one fork", len(chats))
+			}
+			chat, fork := chats[0], chats[1]
+			if chat.ID != chatID || chat.ParentID != nil {
+				t.Errorf("chat %d has parent %v, want chat %d without one", chat.ID, chat.ParentID, chatID)
+			}
+			if chat.Title != tt.title || results[0].Title != tt.title {
+				t.Errorf("title = %q, result says %q, want %q", chat.Title, results[0].Title, tt.title)
+			}
+			if fork.ParentID == nil || *fork.ParentID != chatID {
+				t.Errorf("fork parent = %v, want %d", fork.ParentID, chatID)
+			}
+			if fork.SourceID == nil || *fork.SourceID != tt.forkSourceID {
+				t.Errorf("fork source ID = %v, want %s", fork.SourceID, tt.forkSourceID)
+			}
+
+			messages := importedMessages(t, s, chat.ID)
+			if got := contents(messages); fmt.Sprint(got) != fmt.Sprint(tt.chat) {
+				t.Errorf("chat messages = %q, want %q", got, tt.chat)
+			}
+			if got := contents(importedMessages(t, s, fork.ID)); fmt.Sprint(got) != fmt.Sprint(tt.fork) {
+				t.Errorf("fork messages = %q, want %q", got, tt.fork)
+			}
+			var forkMessage models.Message
+			if fork.ForkMessageID == nil {
+				t.Error("fork has no fork message")
+			} else if err := s.DB.First(&forkMessage, *fork.ForkMessageID).Error; err != nil {
+				t.Errorf("loading fork message: %v", err)
+			} else if forkMessage.ChatID != chatID || forkMessage.Content != tt.forkMessage {
+				t.Errorf("fork message is %q in chat %d, want %q in chat %d", forkMessage.Content, forkMessage.ChatID, tt.forkMessage, chatID)
+			}
+			for _, chatID := range []uint{chat.ID, fork.ID} {
+				var created []string
+				if err := s.DB.Model(&models.Message{}).Where("chat_id = ?", chatID).Order("id ASC").Pluck("created_at", &created).Error; err != nil {
+					t.Fatalf("loading timestamps: %v", err)
+				}
+				for i := 1; i < len(created); i++ {
+					if created[i] < created[i-1] {
+						t.Errorf("chat %d timestamps go back: %q", chatID, created)
+						break
+					}
+				}
+			}
+
+			again, err := s.Import([]byte(tt.export))
+			if err != nil {
+				t.Fatalf("second Import: %v", err)
+			}
+			if len(again) != 1 || !again[0].Skipped || again[0].ChatID != chatID {
+				t.Errorf("second import results = %+v, want chat %d skipped", again, chatID)
+			}
+			var count int64
+			if err := s.DB.Model(&models.Chat{}).Count(&count).Error; err != nil {
+				t.Fatalf("counting chats: %v", err)
+			}
+			if count != 2 {
+				t.Errorf("%d chats after the second import, want 2", count)
+			}
+		})
+	}
+}
+
+func TestImportReattachesForkImportedOnItsOwn(t *testing.T) {
+	source := NewChatService(testDB(t))
+	if _, err := source.Import([]byte(claudeFixture)); err != nil {
+		t.Fatalf("Import into source: %v", err)
+	}
+	var sourceFork models.Chat
+	if err := source.DB.Where("parent_id IS NOT NULL").First(&sourceFork).Error; err != nil {
+		t.Fatalf("loading fork: %v", err)
+	}
+	export, err := source.LoadChatExport(sourceFork.ID, false)
+	if err != nil {
+		t.Fatalf("LoadChatExport: %v", err)
+	}
+	var forkJSON bytes.Buffer
+	if err := RenderChatExport(&forkJSON, export, "json"); err != nil {
+		t.Fatalf("RenderChatExport: %v", err)
+	}
+
+	s := NewChatService(testDB(t))
+	forkResults, err := s.Import(forkJSON.Bytes())
+	if err != nil {
+		t.Fatalf("Import of the fork: %v", err)
+	}
+	results, err := s.Import([]byte(claudeFixture))
+	if err != nil {
+		t.Fatalf("Import of the conversation: %v", err)
+	}
+
+	var fork models.Chat
+	if err := s.DB.First(&fork, forkResults[0].ChatID).Error; err != nil {
+		t.Fatalf("loading fork: %v", err)
+	}
+	if fork.ParentID == nil || *fork.ParentID != results[0].ChatID {
+		t.Errorf("fork parent = %v, want %d", fork.ParentID, results[0].ChatID)
+	}
+	var forkMessage models.Message
+	if fork.ForkMessageID == nil {
+		t.Error("fork has no fork message")
+	} else if err := s.DB.First(&forkMessage, *fork.ForkMessageID).Error; err != nil {
+		t.Errorf("loading fork message: %v", err)
+	} else if forkMessage.Content != "New reply" {
+		t.Errorf("fork message = %q, want the reply it replaces", forkMessage.Content)
+	}
+	var count int64
+	if err := s.DB.Model(&models.Chat{}).Count(&count).Error; err != nil {
+		t.Fatalf("counting chats: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("%d chats, want the conversation and its reattached fork", count)
+	}
+}
+
+func TestImportSkipsClaudeMessagesWithoutText(t *testing.T) {
+	s := NewChatService(testDB(t))
+	results, err := s.Import([]byte(`[{
+		"uuid": "conv-3",
+		"name": "Tools",
+		"created_at": "2024-01-01T10:00:00Z",
+		"current_leaf_message_uuid": "a2",
+		"chat_messages": [
+			{"uuid": "u1", "parent_message_uuid": "00000000-0000-4000-8000-000000000000", "sender": "human", "text": "Look it up", "created_at": "2024-01-01T10:00:00Z"},
+			{"uuid": "a1", "parent_message_uuid": "u1", "sender": "assistant", "text": "", "content": [{"type": "tool_use"}], "created_at": "2024-01-01T10:00:05Z"},
+			{"uuid": "a2", "parent_message_uuid": "a1", "sender": "assistant", "text": "Found it", "created_at": "2024-01-01T10:00:10Z"}
+		]
+	}]`))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("results = %+v, want one chat", results)
+	}
+	want := []string{"user: Look it up", "assistant: Found it"}
+	if got := contents(importedMessages(t, s, results[0].ChatID)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+}
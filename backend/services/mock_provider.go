@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+// MockProvider is an offline provider for local development and CI. It never
+// touches the network and its output depends only on the request, so the same
+// conversation always produces the same stream.
+//
+// The model name picks the behaviour:
+//   - mock/echo repeats the last user message back
+//   - mock/scripted answers with Replies, one per assistant turn
+//   - mock/reasoning echoes like mock/echo after streaming some reasoning
+//   - mock/tools calls a tool when the last user message reads
+//     "<tool> <json arguments>" and names an offered tool, then answers
+//     "Tool result: <result>"; otherwise it echoes
+//   - mock/error fails before any chunk is sent
+//
+// Each word is one chunk and one completion token; max_tokens truncates the
+// reply with finish reason "length".
+type MockProvider struct {
+	Replies          []string      // Scripted replies, indexed by assistant turn
+	ChunkDelay       time.Duration // Latency before each streamed chunk
+	PromptTokens     int           // Reported prompt tokens, zero to count words
+	CompletionTokens int           // Reported completion tokens, zero to count chunks
+	Err              string        // Injected error message, empty to succeed
+	ErrAfterChunks   int           // Number of chunks streamed before Err is raised
+}
+
+// NewMockProviderFromEnv builds a mock provider configured by MOCK_REPLIES
+// (a JSON array of strings), MOCK_LATENCY_MS, MOCK_PROMPT_TOKENS,
+// MOCK_COMPLETION_TOKENS, MOCK_ERROR and MOCK_ERROR_AFTER.
+func NewMockProviderFromEnv() *MockProvider {
+	p := &MockProvider{
+		Err: os.Getenv("MOCK_ERROR"),
+	}
+	if replies := os.Getenv("MOCK_REPLIES"); replies != "" {
+		if err := json.Unmarshal([]byte(replies), &p.Replies); err != nil {
+			fmt.Printf("Ignoring invalid MOCK_REPLIES: %v\n", err)
+		}
+	}
+	p.ChunkDelay = time.Duration(envInt("MOCK_LATENCY_MS")) * time.Millisecond
+	p.PromptTokens = envInt("MOCK_PROMPT_TOKENS")
+	p.CompletionTokens = envInt("MOCK_COMPLETION_TOKENS")
+	p.ErrAfterChunks = envInt("MOCK_ERROR_AFTER")
+	return p
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) StreamChat(ctx context.Context, req CompletionRequest, onChunk func(StreamResponse) error) (*UsageData, error) {
+	if req.Model == "mock/error" {
+		return nil, &UpstreamError{StatusCode: 500, Message: p.errMessage()}
+	}
+
+	// Write the reply as an SSE stream and parse it back the same way the
+	// real providers are parsed
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.writeStream(ctx, pw, req))
+	}()
+	defer pr.Close()
+
+	return readSSEStream(pr, onChunk)
+}
+
+func (p *MockProvider) Complete(ctx context.Context, req CompletionRequest) (*ChatResponse, error) {
+	if req.Model == "mock/error" || p.Err != "" {
+		return nil, &UpstreamError{StatusCode: 500, Message: p.errMessage()}
+	}
+	if err := sleepContext(ctx, p.ChunkDelay); err != nil {
+		return nil, err
+	}
+
+	if call := p.toolCall(req); call != nil {
+		return &ChatResponse{
+			ID:    fmt.Sprintf("mock-%d", len(req.Messages)),
+			Model: req.Model,
+			Choices: []Choice{{
+				Message:      ChatMessage{Role: "assistant", ToolCalls: []models.ToolCall{*call}},
+				FinishReason: "tool_calls",
+			}},
+			Usage: p.usage(req, 1, 0),
+		}, nil
+	}
+
+	words, finishReason := p.words(req)
+	reasoning := p.reasoning(req)
+	message := ChatMessage{Role: "assistant", Content: strings.Join(words, "")}
+	if req.Reasoning == nil || !req.Reasoning.Exclude {
+		message.Reasoning = strings.Join(reasoning, "")
+	}
+	return &ChatResponse{
+		ID:    fmt.Sprintf("mock-%d", len(req.Messages)),
+		Model: req.Model,
+		Choices: []Choice{{
+			Message:      message,
+			FinishReason: finishReason,
+		}},
+		Usage: p.usage(req, len(words), len(reasoning)),
+	}, nil
+}
+
+func (p *MockProvider) writeStream(ctx context.Context, w io.Writer, req CompletionRequest) error {
+	id := fmt.Sprintf("mock-%d", len(req.Messages))
+	if call := p.toolCall(req); call != nil {
+		return p.writeToolCall(ctx, w, req, id, *call)
+	}
+	words, finishReason := p.words(req)
+	reasoning := p.reasoning(req)
+
+	if req.Reasoning == nil || !req.Reasoning.Exclude {
+		for _, word := range reasoning {
+			if err := sleepContext(ctx, p.ChunkDelay); err != nil {
+				return err
+			}
+			chunk := StreamResponse{
+				ID:    id,
+				Model: req.Model,
+				Choices: []StreamChoice{{
+					Delta: StreamDelta{Role: "assistant", Reasoning: word},
+				}},
+			}
+			if err := writeSSEData(w, chunk); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, word := range words {
+		if p.Err != "" && i == p.ErrAfterChunks {
+			return errors.New(p.errMessage())
+		}
+		if err := sleepContext(ctx, p.ChunkDelay); err != nil {
+			return err
+		}
+
+		chunk := StreamResponse{
+			ID:    id,
+			Model: req.Model,
+			Choices: []StreamChoice{{
+				Delta: StreamDelta{Role: "assistant", Content: word},
+			}},
+		}
+		if i == len(words)-1 {
+			chunk.Choices[0].FinishReason = finishReason
+		}
+		if err := writeSSEData(w, chunk); err != nil {
+			return err
+		}
+	}
+	if p.Err != "" {
+		return errors.New(p.errMessage())
+	}
+
+	usage := p.usage(req, len(words), len(reasoning))
+	if err := writeSSEData(w, StreamResponse{ID: id, Model: req.Model, Choices: []StreamChoice{}, Usage: usage}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n\n")
+	return err
+}
+
+// writeToolCall streams a tool call the way OpenAI does: the ID and name
+// first, then the arguments.
+func (p *MockProvider) writeToolCall(ctx context.Context, w io.Writer, req CompletionRequest, id string, call models.ToolCall) error {
+	deltas := []ToolCallDelta{
+		{ID: call.ID, Type: call.Type, Function: models.ToolCallFunction{Name: call.Function.Name}},
+		{Function: models.ToolCallFunction{Arguments: call.Function.Arguments}},
+	}
+	for i, delta := range deltas {
+		if err := sleepContext(ctx, p.ChunkDelay); err != nil {
+			return err
+		}
+		chunk := StreamResponse{
+			ID:    id,
+			Model: req.Model,
+			Choices: []StreamChoice{{
+				Delta: StreamDelta{Role: "assistant", ToolCalls: []ToolCallDelta{delta}},
+			}},
+		}
+		if i == len(deltas)-1 {
+			chunk.Choices[0].FinishReason = "tool_calls"
+		}
+		if err := writeSSEData(w, chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSSEData(w, StreamResponse{ID: id, Model: req.Model, Choices: []StreamChoice{}, Usage: p.usage(req, 1, 0)}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n\n")
+	return err
+}
+
+// toolCall returns the call mock/tools makes for the request, nil to answer
+// with text.
+func (p *MockProvider) toolCall(req CompletionRequest) *models.ToolCall {
+	if req.Model != "mock/tools" || req.ToolChoice == "none" || len(req.Messages) == 0 {
+		return nil
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != "user" {
+		return nil
+	}
+	name, args, _ := strings.Cut(strings.TrimSpace(last.Content), " ")
+	for _, tool := range req.Tools {
+		if tool.Function.Name != name {
+			continue
+		}
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		return &models.ToolCall{
+			ID:       fmt.Sprintf("call_mock_%d", len(req.Messages)),
+			Type:     "function",
+			Function: models.ToolCallFunction{Name: name, Arguments: strings.TrimSpace(args)},
+		}
+	}
+	return nil
+}
+
+func (p *MockProvider) errMessage() string {
+	if p.Err != "" {
+		return p.Err
+	}
+	return "mock provider error"
+}
+
+// reply picks the deterministic answer for the request.
+func (p *MockProvider) reply(req CompletionRequest) string {
+	if req.Model == "mock/scripted" && len(p.Replies) > 0 {
+		turn := 0
+		for _, msg := range req.Messages {
+			if msg.Role == "assistant" {
+				turn++
+			}
+		}
+		return p.Replies[turn%len(p.Replies)]
+	}
+	if req.Model == "mock/tools" && len(req.Messages) > 0 {
+		if last := req.Messages[len(req.Messages)-1]; last.Role == "tool" {
+			return "Tool result: " + last.Content
+		}
+	}
+
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			return req.Messages[i].Content
+		}
+	}
+	return "(empty)"
+}
+
+// words splits the reply into chunks, honouring max_tokens.
+func (p *MockProvider) words(req CompletionRequest) ([]string, string) {
+	words := strings.SplitAfter(p.reply(req), " ")
+	if req.MaxTokens != nil && *req.MaxTokens > 0 && *req.MaxTokens < len(words) {
+		return words[:*req.MaxTokens], "length"
+	}
+	return words, "stop"
+}
+
+// reasoning returns the thinking chunks of mock/reasoning, nil for other models.
+func (p *MockProvider) reasoning(req CompletionRequest) []string {
+	if req.Model != "mock/reasoning" {
+		return nil
+	}
+	return strings.SplitAfter("The user said: "+p.reply(req), " ")
+}
+
+func (p *MockProvider) usage(req CompletionRequest, chunks, reasoningChunks int) *UsageData {
+	promptTokens := p.PromptTokens
+	if promptTokens == 0 {
+		for _, msg := range req.Messages {
+			promptTokens += len(strings.Fields(msg.Content))
+		}
+	}
+	completionTokens := p.CompletionTokens
+	if completionTokens == 0 {
+		completionTokens = chunks + reasoningChunks
+	}
+	usage := &UsageData{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+	if reasoningChunks > 0 {
+		usage.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: reasoningChunks}
+	}
+	return usage
+}
+
+func (p *MockProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	free := ModelPricing{Prompt: "0", Completion: "0"}
+	text := ModelArchitecture{Modality: "text->text"}
+	return []ModelInfo{
+		{ID: "mock/echo", Name: "Mock: Echo", Description: "Repeats the last user message", Pricing: free, Architecture: text, Provider: p.Name()},
+		{ID: "mock/scripted", Name: "Mock: Scripted", Description: "Answers with the configured MOCK_REPLIES", Pricing: free, Architecture: text, Provider: p.Name()},
+		{ID: "mock/reasoning", Name: "Mock: Reasoning", Description: "Thinks out loud, then repeats the last user message", Pricing: free, Architecture: text, Provider: p.Name()},
+		{ID: "mock/tools", Name: "Mock: Tools", Description: "Calls the tool named in the last user message", Pricing: free, Architecture: text, Provider: p.Name()},
+		{ID: "mock/error", Name: "Mock: Error", Description: "Always fails", Pricing: free, Architecture: text, Provider: p.Name()},
+	}, nil
+}
+
+// sleepContext waits for d or until ctx is cancelled, whichever is first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// writeSSEData writes v as a single server-sent event data line.
+func writeSSEData(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
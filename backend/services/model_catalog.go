@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// ModelFilter narrows the model catalog. Zero values and nil limits match
+// every model.
+type ModelFilter struct {
+	Provider           string
+	Modality           string   // Part of the modality, e.g. "image" or "text->text"
+	MinContextLength   int      // Models with an unknown context length never match
+	MaxPromptPrice     *float64 // USD per million prompt tokens, zero for free models
+	MaxCompletionPrice *float64 // USD per million completion tokens
+}
+
+func (f ModelFilter) matches(m ModelInfo) bool {
+	if f.Provider != "" && m.Provider != f.Provider {
+		return false
+	}
+	if f.Modality != "" && !strings.Contains(m.Architecture.Modality, f.Modality) {
+		return false
+	}
+	if f.MinContextLength > 0 && m.ContextLength < f.MinContextLength {
+		return false
+	}
+	if f.MaxPromptPrice != nil && !priceAtMost(m.Pricing.Prompt, *f.MaxPromptPrice) {
+		return false
+	}
+	if f.MaxCompletionPrice != nil && !priceAtMost(m.Pricing.Completion, *f.MaxCompletionPrice) {
+		return false
+	}
+	return true
+}
+
+// priceAtMost compares a per-token price, as providers report it, against a
+// limit per million tokens. Unknown and negative (variable) prices never match.
+func priceAtMost(perToken string, perMillion float64) bool {
+	price, err := strconv.ParseFloat(perToken, 64)
+	if err != nil || price < 0 {
+		return false
+	}
+	return price*1e6 <= perMillion
+}
+
+// ListModels merges the model lists of all registered providers, keeping
+// those that match filter. Each list is cached in the database for
+// ModelCacheTTL; refresh bypasses the cache. Providers that cannot be
+// reached are served from the cache however old it is, or skipped.
+func (s *ChatService) ListModels(ctx context.Context, filter ModelFilter, refresh bool) []ModelInfo {
+	names := make([]string, 0, len(s.Providers))
+	for name := range s.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	all := []ModelInfo{}
+	for _, name := range names {
+		if filter.Provider != "" && name != filter.Provider {
+			continue
+		}
+		providerModels, err := s.providerModels(ctx, s.Providers[name], refresh)
+		if err != nil {
+			fmt.Printf("Error listing models for provider %s: %v\n", name, err)
+			continue
+		}
+		for _, m := range providerModels {
+			if filter.matches(m) {
+				all = append(all, m)
+			}
+		}
+	}
+	return all
+}
+
+// acceptsImages reports whether a model takes image input according to its
+// provider's cached catalog, however old. The catalog is never fetched on
+// the request path, so models missing from the cache, or a provider never
+// listed, are assumed to accept images and left for the upstream to judge.
+func (s *ChatService) acceptsImages(c candidate) bool {
+	var cache models.ModelListCache
+	if err := s.DB.Where("provider = ?", c.provider.Name()).First(&cache).Error; err != nil {
+		return true
+	}
+	list, err := decodeModelCache(cache)
+	if err != nil {
+		return true
+	}
+	for _, m := range list {
+		if m.ID == c.model {
+			input, _, _ := strings.Cut(m.Architecture.Modality, "->")
+			return strings.Contains(input, "image")
+		}
+	}
+	return true
+}
+
+func (s *ChatService) providerModels(ctx context.Context, p Provider, refresh bool) ([]ModelInfo, error) {
+	var cache models.ModelListCache
+	err := s.DB.Where("provider = ?", p.Name()).First(&cache).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("error loading cached models: %v", err)
+	}
+	cached := err == nil
+
+	if cached && !refresh {
+		if fetchedAt, err := time.Parse(time.RFC3339, cache.FetchedAt); err == nil && time.Since(fetchedAt) < s.ModelCacheTTL {
+			return decodeModelCache(cache)
+		}
+	}
+
+	list, err := p.ListModels(ctx)
+	if err != nil {
+		if cached {
+			fmt.Printf("Serving models of provider %s cached at %s: %v\n", p.Name(), cache.FetchedAt, err)
+			return decodeModelCache(cache)
+		}
+		return nil, err
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding models: %v", err)
+	}
+	cache.Provider = p.Name()
+	cache.Models = string(data)
+	cache.FetchedAt = time.Now().Format(time.RFC3339)
+	if err := s.DB.Save(&cache).Error; err != nil {
+		fmt.Printf("Error caching models of provider %s: %v\n", p.Name(), err)
+	}
+	return list, nil
+}
+
+func decodeModelCache(cache models.ModelListCache) ([]ModelInfo, error) {
+	var list []ModelInfo
+	if err := json.Unmarshal([]byte(cache.Models), &list); err != nil {
+		return nil, fmt.Errorf("error decoding cached models: %v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OpenAICompatibleProvider talks to any server implementing the OpenAI
+// chat completions API: OpenRouter, Ollama, llama.cpp, vLLM and the like.
+type OpenAICompatibleProvider struct {
+	ProviderName string
+	BaseURL      string
+	APIKey       string
+	Headers      map[string]string // Extra headers sent with every request
+	// StripPrefix removes "<ProviderName>/" from model IDs before they are
+	// sent upstream, so "ollama/llama3" reaches Ollama as "llama3".
+	StripPrefix bool
+	HTTP        *UpstreamClient // Nil to use DefaultUpstreamClient
+}
+
+// EndpointConfig describes a self-hosted OpenAI-compatible server.
+type EndpointConfig struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"baseUrl"`
+	APIKey  string `json:"apiKey,omitempty"`
+}
+
+// NewOpenAICompatibleProvider creates a provider for a self-hosted endpoint.
+// Its models are addressed as "<name>/<model>".
+func NewOpenAICompatibleProvider(cfg EndpointConfig) *OpenAICompatibleProvider {
+	return &OpenAICompatibleProvider{
+		ProviderName: cfg.Name,
+		BaseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+		APIKey:       cfg.APIKey,
+		StripPrefix:  true,
+	}
+}
+
+// LoadEndpointsFromEnv reads PROVIDER_ENDPOINTS, a JSON array of endpoint
+// configs, e.g. [{"name":"ollama","baseUrl":"http://localhost:11434/v1"}].
+func LoadEndpointsFromEnv() ([]EndpointConfig, error) {
+	raw := os.Getenv("PROVIDER_ENDPOINTS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var endpoints []EndpointConfig
+	if err := json.Unmarshal([]byte(raw), &endpoints); err != nil {
+		return nil, fmt.Errorf("invalid PROVIDER_ENDPOINTS: %v", err)
+	}
+	for _, e := range endpoints {
+		if e.Name == "" || e.BaseURL == "" {
+			return nil, fmt.Errorf("invalid PROVIDER_ENDPOINTS: every endpoint needs a name and baseUrl")
+		}
+		if strings.Contains(e.Name, "/") {
+			return nil, fmt.Errorf("invalid PROVIDER_ENDPOINTS: endpoint name %q must not contain '/'", e.Name)
+		}
+	}
+	return endpoints, nil
+}
+
+func (p *OpenAICompatibleProvider) Name() string {
+	return p.ProviderName
+}
+
+// upstreamModel returns the model ID as the upstream server knows it.
+func (p *OpenAICompatibleProvider) upstreamModel(model string) string {
+	if p.StripPrefix {
+		return strings.TrimPrefix(model, p.ProviderName+"/")
+	}
+	return model
+}
+
+func (p *OpenAICompatibleProvider) newRequest(method, path string, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequest(method, p.BaseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	// Add required headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.APIKey))
+	}
+	for k, v := range p.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	return httpReq, nil
+}
+
+// postCompletion sends the completion request and checks the response
+// status. The caller must close the returned body.
+func (p *OpenAICompatibleProvider) postCompletion(ctx context.Context, req CompletionRequest) (*http.Response, error) {
+	req.Model = p.upstreamModel(req.Model)
+
+	// Ask for a final usage chunk; OpenRouter sends one regardless, but
+	// Ollama and vLLM only do so when requested
+	apiReq := struct {
+		CompletionRequest
+		StreamOptions *struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options,omitempty"`
+	}{CompletionRequest: req}
+	if req.Stream {
+		apiReq.StreamOptions = &struct {
+			IncludeUsage bool `json:"include_usage"`
+		}{IncludeUsage: true}
+	}
+
+	jsonData, err := json.Marshal(apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	httpReq, err := p.newRequest("POST", "/chat/completions", jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams may pause between events; other answers arrive all at once
+	send := upstreamClient(p.HTTP).Do
+	if req.Stream {
+		send = upstreamClient(p.HTTP).Stream
+	}
+	resp, err := send(ctx, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+
+	// Check for error response
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errorResp); err != nil {
+			return nil, newUpstreamError(resp, "")
+		}
+		return nil, newUpstreamError(resp, errorResp.Error.Message)
+	}
+	return resp, nil
+}
+
+func (p *OpenAICompatibleProvider) StreamChat(ctx context.Context, req CompletionRequest, onChunk func(StreamResponse) error) (*UsageData, error) {
+	req.Stream = true
+	resp, err := p.postCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return readSSEStream(resp.Body, onChunk)
+}
+
+func (p *OpenAICompatibleProvider) Complete(ctx context.Context, req CompletionRequest) (*ChatResponse, error) {
+	req.Stream = false
+	resp, err := p.postCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	return &chatResp, nil
+}
+
+func (p *OpenAICompatibleProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := p.newRequest("GET", "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := upstreamClient(p.HTTP).Do(ctx, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching models: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response with status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data []ModelInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error decoding models: %v", err)
+	}
+
+	for i := range list.Data {
+		m := &list.Data[i]
+		m.Provider = p.ProviderName
+		if p.StripPrefix {
+			m.ID = p.ProviderName + "/" + m.ID
+		}
+		// Self-hosted servers only report IDs
+		if m.Name == "" {
+			m.Name = m.ID
+		}
+		if m.Pricing.Prompt == "" {
+			m.Pricing = ModelPricing{Prompt: "0", Completion: "0"}
+		}
+		if m.Architecture.Modality == "" {
+			m.Architecture.Modality = "text->text"
+		}
+	}
+	return list.Data, nil
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+// Provider is an LLM backend capable of serving chat completions.
+// OpenRouter is one implementation; others can be registered on the
+// ChatService and selected per chat.
+type Provider interface {
+	// Name is the identifier used to select the provider for a chat.
+	Name() string
+	// StreamChat sends the completion request upstream and calls onChunk for
+	// every chunk as it arrives. It returns the token usage reported by the
+	// upstream, or nil if none was reported.
+	// Cancelling ctx aborts the upstream request.
+	StreamChat(ctx context.Context, req CompletionRequest, onChunk func(StreamResponse) error) (*UsageData, error)
+	// Complete sends the request upstream without streaming and returns the
+	// whole answer.
+	Complete(ctx context.Context, req CompletionRequest) (*ChatResponse, error)
+	// ListModels returns the models this provider can serve.
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ErrInvalidParams is returned for generation parameters a provider cannot
+// send as given.
+var ErrInvalidParams = errors.New("invalid generation parameters")
+
+// ParamsValidator is implemented by providers that would have to change some
+// combinations of generation parameters to send them. Such requests are
+// refused instead, so the parameters stored with an answer are the ones it
+// was generated with.
+type ParamsValidator interface {
+	// ValidateParams returns an error wrapping ErrInvalidParams when params
+	// cannot be sent unchanged.
+	ValidateParams(params models.GenerationParams) error
+}
+
+// CompletionRequest is the provider-agnostic request sent upstream. Its
+// generation parameters are inlined, as OpenAI-compatible APIs expect them.
+type CompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	models.GenerationParams
+	Tools []ToolDefinition `json:"tools,omitempty"`
+	// ToolChoice is "none" to forbid tool calls, empty to let the model decide
+	ToolChoice string `json:"tool_choice,omitempty"`
+}
+
+// ToolDefinition offers a function to the model, in OpenAI's format.
+type ToolDefinition struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON schema of the arguments
+}
+
+type ModelPricing struct {
+	Prompt     string `json:"prompt"`
+	Completion string `json:"completion"`
+	Image      string `json:"image,omitempty"`
+	Request    string `json:"request,omitempty"`
+}
+
+type ModelArchitecture struct {
+	Modality     string  `json:"modality"`
+	Tokenizer    string  `json:"tokenizer,omitempty"`
+	InstructType *string `json:"instruct_type"`
+}
+
+// ModelInfo describes a model in the same shape as OpenRouter's model list,
+// tagged with the provider that serves it.
+type ModelInfo struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description,omitempty"`
+	ContextLength int               `json:"context_length,omitempty"`
+	Pricing       ModelPricing      `json:"pricing"`
+	Architecture  ModelArchitecture `json:"architecture"`
+	Provider      string            `json:"provider"`
+}
+
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Reasoning is the model's thinking, sent back upstream only on request.
+	// Some OpenAI-compatible servers call it reasoning_content instead.
+	Reasoning        string `json:"reasoning,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// ToolCalls are the calls an assistant message made; ToolCallID is the
+	// call a tool message answers.
+	ToolCalls  []models.ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	// Parts are the message's attachments. When there are any, the message
+	// is sent with Content and Parts together as a list of content parts.
+	Parts []ContentPart `json:"-"`
+	// Thinking holds the signed reasoning of an assistant turn that called
+	// tools, which Anthropic requires back while the tool loop lasts.
+	Thinking []ThinkingBlock `json:"-"`
+}
+
+// ThinkingBlock is a piece of reasoning as Anthropic issued it: the text
+// with its signature, or the encrypted data of a redacted block.
+type ThinkingBlock struct {
+	Thinking  string
+	Signature string
+	Redacted  string
+}
+
+// MarshalJSON sends a message with attachments as multimodal content parts.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	var parts []ContentPart
+	if m.Content != "" {
+		parts = append(parts, ContentPart{Type: "text", Text: m.Content})
+	}
+	parts = append(parts, m.Parts...)
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), parts})
+}
+
+// ContentPart is one part of a multimodal message, in OpenAI's format: text,
+// an image or a file, the latter two as data URLs.
+type ContentPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *ImageURLPart `json:"image_url,omitempty"`
+	File     *FilePart     `json:"file,omitempty"`
+
+	fileName string // Name of the attachment, for models that cannot read it
+}
+
+type ImageURLPart struct {
+	URL string `json:"url"`
+}
+
+type FilePart struct {
+	FileName string `json:"filename"`
+	FileData string `json:"file_data"`
+}
+
+type ChatResponse struct {
+	ID       string     `json:"id"`
+	Model    string     `json:"model,omitempty"`
+	Provider string     `json:"provider,omitempty"` // Backend OpenRouter routed the request to
+	Choices  []Choice   `json:"choices"`
+	Usage    *UsageData `json:"usage,omitempty"`
+	Error    *APIError  `json:"error,omitempty"`
+}
+
+type Choice struct {
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type APIError struct {
+	Message string `json:"message"`
+}
+
+// UpstreamError is returned when a provider answers with a non-200 status.
+// RetryAfter is the delay requested by the upstream, zero if none was given.
+type UpstreamError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("error response with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("API error: %s (code: %d)", e.Message, e.StatusCode)
+}
+
+// newUpstreamError builds the error for a failed response, reading the
+// Retry-After header when it holds a number of seconds.
+func newUpstreamError(resp *http.Response, message string) *UpstreamError {
+	e := &UpstreamError{StatusCode: resp.StatusCode, Message: message}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+type StreamDelta struct {
+	Role             string          `json:"role,omitempty"`
+	Content          string          `json:"content"`
+	Reasoning        string          `json:"reasoning,omitempty"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+	Thinking         []ThinkingBlock `json:"-"` // Completed thinking blocks
+}
+
+// ToolCallDelta is a piece of a streamed tool call. The first piece of each
+// call carries its ID and name; the arguments arrive in fragments, and Index
+// tells which call a fragment belongs to.
+type ToolCallDelta struct {
+	Index    int                     `json:"index"`
+	ID       string                  `json:"id,omitempty"`
+	Type     string                  `json:"type,omitempty"`
+	Function models.ToolCallFunction `json:"function"`
+}
+
+// ReasoningText returns the reasoning carried by the delta under either name.
+func (d StreamDelta) ReasoningText() string {
+	if d.Reasoning != "" {
+		return d.Reasoning
+	}
+	return d.ReasoningContent
+}
+
+type StreamChoice struct {
+	Index        int         `json:"index"`
+	Delta        StreamDelta `json:"delta"`
+	FinishReason string      `json:"finish_reason,omitempty"`
+}
+
+type UsageData struct {
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ReasoningTokens returns how many of the completion tokens were spent
+// reasoning, zero if the provider did not say.
+func (u *UsageData) ReasoningTokens() int {
+	if u == nil || u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
+
+type StreamResponse struct {
+	ID       string         `json:"id"`
+	Model    string         `json:"model,omitempty"`
+	Provider string         `json:"provider,omitempty"` // Backend OpenRouter routed the request to
+	Choices  []StreamChoice `json:"choices"`
+	Usage    *UsageData     `json:"usage,omitempty"`
+}
+
+// chunkFromResponse turns a complete answer into a single chunk, so answers
+// that were not streamed are handled like streamed ones.
+func chunkFromResponse(resp *ChatResponse) StreamResponse {
+	chunk := StreamResponse{ID: resp.ID, Model: resp.Model, Provider: resp.Provider, Usage: resp.Usage}
+	for i, choice := range resp.Choices {
+		delta := StreamDelta{
+			Role:             choice.Message.Role,
+			Content:          choice.Message.Content,
+			Reasoning:        choice.Message.Reasoning,
+			ReasoningContent: choice.Message.ReasoningContent,
+			Thinking:         choice.Message.Thinking,
+		}
+		for j, call := range choice.Message.ToolCalls {
+			delta.ToolCalls = append(delta.ToolCalls, ToolCallDelta{Index: j, ID: call.ID, Type: call.Type, Function: call.Function})
+		}
+		chunk.Choices = append(chunk.Choices, StreamChoice{Index: i, Delta: delta, FinishReason: choice.FinishReason})
+	}
+	return chunk
+}
+
+// readSSEEvents splits a server-sent event stream into events, calling
+// onEvent with each event's type and data. It stops at EOF or `[DONE]`.
+func readSSEEvents(body io.Reader, onEvent func(event string, data []byte) error) error {
+	reader := bufio.NewReader(body)
+	var event string
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+			// A blank line ends the event
+			event = ""
+		case bytes.HasPrefix(line, []byte("event:")):
+			event = string(bytes.TrimSpace(bytes.TrimPrefix(line, []byte("event:"))))
+		case bytes.HasPrefix(line, []byte("data:")):
+			data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+			if string(data) == "[DONE]" {
+				return nil
+			}
+			if cbErr := onEvent(event, data); cbErr != nil {
+				return cbErr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("error reading stream: %v", err)
+		}
+	}
+}
+
+// readSSEStream parses an OpenAI-style server-sent event stream, calling
+// onChunk for each decoded chunk until `[DONE]` or EOF.
+func readSSEStream(body io.Reader, onChunk func(StreamResponse) error) (*UsageData, error) {
+	var usage *UsageData
+	err := readSSEEvents(body, func(_ string, data []byte) error {
+		var chunk StreamResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			log.Printf("Skipping stream chunk that is not valid JSON: %v: %.200s", err, data)
+			return nil
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		return onChunk(chunk)
+	})
+	return usage, err
+}
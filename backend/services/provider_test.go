@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseServer serves body as an event stream, writing and flushing each piece
+// separately so frames reach the client split at arbitrary points.
+func sseServer(t *testing.T, pieces ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range pieces {
+			fmt.Fprint(w, piece)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testProvider(baseURL string) *OpenAICompatibleProvider {
+	return &OpenAICompatibleProvider{
+		ProviderName: "local",
+		BaseURL:      baseURL,
+		StripPrefix:  true,
+		HTTP:         NewUpstreamClient(time.Second, time.Second, time.Second),
+	}
+}
+
+func TestStreamChatSplitFrames(t *testing.T) {
+	server := sseServer(t,
+		`data: {"id":"gen-1","choices":[{"delta":{"content":"Hel`,
+		`lo"}}]}`+"\n",
+		"\n: keep-alive comment\n\ndata: ",
+		`{"choices":[{"delta":{"content":", world"},"finish_reason":"stop"}]}`+"\n\n",
+		`data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`+"\r\n\r\n",
+		"data: [DONE]\n\n",
+		`data: {"choices":[{"delta":{"content":" after done"}}]}`+"\n\n",
+	)
+
+	var content, finishReason, id string
+	usage, err := testProvider(server.URL).StreamChat(context.Background(), CompletionRequest{Model: "local/model"}, func(chunk StreamResponse) error {
+		if chunk.ID != "" {
+			id = chunk.ID
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamChat: %v", err)
+	}
+	if content != "Hello, world" {
+		t.Errorf("content = %q, want %q", content, "Hello, world")
+	}
+	if id != "gen-1" || finishReason != "stop" {
+		t.Errorf("id, finish reason = %q, %q, want gen-1, stop", id, finishReason)
+	}
+	if usage == nil || usage.PromptTokens != 3 || usage.CompletionTokens != 2 || usage.TotalTokens != 5 {
+		t.Errorf("usage = %+v, want 3/2/5", usage)
+	}
+}
+
+func TestReadSSEEvents(t *testing.T) {
+	stream := "event: message_start\ndata: {\"a\":1}\n\ndata: {\"b\":2}\n\nevent: ping\ndata:{}\n\ndata: [DONE]\ndata: {\"c\":3}\n"
+
+	var got []string
+	err := readSSEEvents(strings.NewReader(stream), func(event string, data []byte) error {
+		got = append(got, event+" "+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readSSEEvents: %v", err)
+	}
+	want := []string{`message_start {"a":1}`, ` {"b":2}`, `ping {}`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestReadSSEEventsStopsOnCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := readSSEEvents(strings.NewReader("data: 1\n\ndata: 2\n\n"), func(string, []byte) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("err, calls = %v, %d, want stop, 1", err, calls)
+	}
+}
+
+func TestStreamChatUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		want       UpstreamError
+	}{
+		{"json error body", 400, "", `{"error":{"message":"max_tokens is too large"}}`, UpstreamError{StatusCode: 400, Message: "max_tokens is too large"}},
+		{"retry after seconds", 429, "3", `{"error":{"message":"rate limited"}}`, UpstreamError{StatusCode: 429, Message: "rate limited", RetryAfter: 3 * time.Second}},
+		{"retry after date is ignored", 503, "Wed, 21 Oct 2015 07:28:00 GMT", `{"error":{"message":"overloaded"}}`, UpstreamError{StatusCode: 503, Message: "overloaded"}},
+		{"invalid retry after", 429, "-1", `{"error":{"message":"rate limited"}}`, UpstreamError{StatusCode: 429, Message: "rate limited"}},
+		{"body that is not json", 502, "", "<html>Bad Gateway</html>", UpstreamError{StatusCode: 502}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := testProvider(server.URL).StreamChat(context.Background(), CompletionRequest{Model: "local/model"}, func(StreamResponse) error {
+				t.Error("onChunk called for a failed response")
+				return nil
+			})
+			var upstreamErr *UpstreamError
+			if !errors.As(err, &upstreamErr) {
+				t.Fatalf("err = %v, want an UpstreamError", err)
+			}
+			if *upstreamErr != tt.want {
+				t.Errorf("err = %+v, want %+v", *upstreamErr, tt.want)
+			}
+		})
+	}
+}
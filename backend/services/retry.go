@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// candidate is one model in a fallback chain together with the provider
+// that serves it.
+type candidate struct {
+	provider Provider
+	model    string
+}
+
+// isRetryable reports whether a failed upstream call may succeed if repeated:
+// rate limits, upstream outages (including Anthropic's 529 overloaded) and
+// connection failures. A response that timed out is not retried, as it
+// would be generated again from scratch.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrResponseTimeout) {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case 408, 429, 500, 502, 503, 504, 529:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isModelUnavailable reports whether an upstream failed because it does not
+// serve the requested model, so another model may still answer.
+func isModelUnavailable(err error) bool {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		return false
+	}
+	if upstreamErr.StatusCode == 404 {
+		return true
+	}
+	message := strings.ToLower(upstreamErr.Message)
+	return strings.Contains(message, "model") &&
+		(strings.Contains(message, "not found") || strings.Contains(message, "not a valid model") || strings.Contains(message, "does not exist"))
+}
+
+// retryDelay returns the wait before the given retry (starting at 0): the
+// upstream's Retry-After if it sent one, otherwise a random delay up to an
+// exponentially growing cap. It reports false when Retry-After asks for
+// longer than RetryMaxDelay, so the next candidate is tried instead.
+func (s *ChatService) retryDelay(attempt int, err error) (time.Duration, bool) {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+		return upstreamErr.RetryAfter, upstreamErr.RetryAfter <= s.RetryMaxDelay
+	}
+	ceiling := s.RetryBaseDelay << attempt
+	if ceiling <= 0 || ceiling > s.RetryMaxDelay {
+		ceiling = s.RetryMaxDelay
+	}
+	if ceiling <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1), true
+}
+
+// withFallbacks calls try for each candidate in turn until one succeeds.
+// Retryable failures are retried up to MaxRetries times with jittered
+// backoff before moving on to the next candidate; a model the upstream
+// does not serve moves on at once. Any other failure, such as a rejected
+// request or key, skips the remaining candidates of the same provider,
+// which would fail the same way, and moves on to another provider's. Once
+// try reports that output has reached the client nothing is retried.
+func (s *ChatService) withFallbacks(ctx context.Context, candidates []candidate, try func(c candidate) (started bool, err error)) (candidate, error) {
+	var last candidate
+	var lastErr error
+	rejectedBy := make(map[Provider]bool)
+	for _, c := range candidates {
+		if rejectedBy[c.provider] {
+			continue
+		}
+		if lastErr != nil {
+			fmt.Printf("Falling back from %s to %s after error: %v\n", last.model, c.model, lastErr)
+		}
+		last = c
+		for attempt := 0; ; attempt++ {
+			started, err := try(c)
+			if err == nil {
+				return c, nil
+			}
+			lastErr = err
+			if started || ctx.Err() != nil {
+				return c, err
+			}
+			if !isRetryable(err) && !isModelUnavailable(err) {
+				rejectedBy[c.provider] = true
+				break
+			}
+			if !isRetryable(err) || attempt >= s.MaxRetries {
+				break
+			}
+
+			delay, ok := s.retryDelay(attempt, err)
+			if !ok {
+				fmt.Printf("Not retrying %s: upstream asked to wait %s\n", c.model, delay)
+				break
+			}
+			fmt.Printf("Retrying %s in %s after error: %v\n", c.model, delay, err)
+			if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+				return c, err
+			}
+		}
+	}
+	return last, lastErr
+}
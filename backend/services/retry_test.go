@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"web/ai-playground/models"
+)
+
+func TestWithFallbacks(t *testing.T) {
+	openRouter, anthropic := &MockProvider{}, &MockProvider{}
+	tests := []struct {
+		name       string
+		candidates []candidate        // Primary and backup of the same provider if nil
+		errs       map[string][]error // Failures of each model's attempts, in order; nil succeeds
+		started    bool               // Whether failing attempts had already streamed output
+		wantCalls  []string
+		wantModel  string
+		wantErr    bool
+	}{
+		{
+			name:      "rate limit is retried",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 429}}},
+			wantCalls: []string{"primary", "primary"},
+			wantModel: "primary",
+		},
+		{
+			name:      "bad gateway is retried",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 502}, &UpstreamError{StatusCode: 502}}},
+			wantCalls: []string{"primary", "primary", "primary"},
+			wantModel: "primary",
+		},
+		{
+			name:      "exhausted retries fall back",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 502}, &UpstreamError{StatusCode: 502}, &UpstreamError{StatusCode: 502}}},
+			wantCalls: []string{"primary", "primary", "primary", "backup"},
+			wantModel: "backup",
+		},
+		{
+			name:      "bad request stops",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 400}}},
+			wantCalls: []string{"primary"},
+			wantModel: "primary",
+			wantErr:   true,
+		},
+		{
+			name:      "rejected key stops",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 401}}},
+			wantCalls: []string{"primary"},
+			wantModel: "primary",
+			wantErr:   true,
+		},
+		{
+			name:       "rejected key falls back to another provider",
+			candidates: []candidate{{openRouter, "primary"}, {openRouter, "backup"}, {anthropic, "other"}},
+			errs:       map[string][]error{"primary": {&UpstreamError{StatusCode: 401}}},
+			wantCalls:  []string{"primary", "other"},
+			wantModel:  "other",
+		},
+		{
+			name:       "payment required falls back to another provider",
+			candidates: []candidate{{openRouter, "primary"}, {anthropic, "other"}},
+			errs:       map[string][]error{"primary": {&UpstreamError{StatusCode: 402}}},
+			wantCalls:  []string{"primary", "other"},
+			wantModel:  "other",
+		},
+		{
+			name:       "rejection by every provider stops",
+			candidates: []candidate{{openRouter, "primary"}, {anthropic, "other"}, {openRouter, "backup"}},
+			errs:       map[string][]error{"primary": {&UpstreamError{StatusCode: 401}}, "other": {&UpstreamError{StatusCode: 401}}},
+			wantCalls:  []string{"primary", "other"},
+			wantModel:  "other",
+			wantErr:    true,
+		},
+		{
+			name:      "unknown model falls back",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 404}}},
+			wantCalls: []string{"primary", "backup"},
+			wantModel: "backup",
+		},
+		{
+			name:      "model not found message falls back",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 400, Message: "Model primary not found"}}},
+			wantCalls: []string{"primary", "backup"},
+			wantModel: "backup",
+		},
+		{
+			name:      "long Retry-After falls back",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 429, RetryAfter: time.Minute}}},
+			wantCalls: []string{"primary", "backup"},
+			wantModel: "backup",
+		},
+		{
+			name:      "failure after output stops",
+			errs:      map[string][]error{"primary": {&UpstreamError{StatusCode: 502}}},
+			started:   true,
+			wantCalls: []string{"primary"},
+			wantModel: "primary",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewChatService(nil)
+			s.RetryBaseDelay = time.Millisecond
+			s.RetryMaxDelay = 10 * time.Millisecond
+
+			candidates := tt.candidates
+			if candidates == nil {
+				candidates = []candidate{{model: "primary"}, {model: "backup"}}
+			}
+			var calls []string
+			answered, err := s.withFallbacks(context.Background(), candidates, func(c candidate) (bool, error) {
+				calls = append(calls, c.model)
+				errs := tt.errs[c.model]
+				if len(errs) == 0 {
+					return false, nil
+				}
+				tt.errs[c.model] = errs[1:]
+				return tt.started, errs[0]
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if answered.model != tt.wantModel {
+				t.Errorf("answered by %q, want %q", answered.model, tt.wantModel)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestChatSyncRecordsFallbackModel(t *testing.T) {
+	db := testDB(t)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "primary" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":{"message":"overloaded"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"local-1","model":%q,"choices":[{"message":{"role":"assistant","content":"from backup"},"finish_reason":"stop"}]}`, req.Model)
+	}))
+	defer local.Close()
+	s := NewChatService(db, NewOpenAICompatibleProvider(EndpointConfig{Name: "local", BaseURL: local.URL}))
+	s.MaxRetries = 1
+	s.RetryBaseDelay = time.Millisecond
+
+	result, err := s.ChatSync(context.Background(), ChatRequest{
+		Model:          "local/primary",
+		FallbackModels: []string{"local/backup"},
+		Messages:       []Message{{Role: "user", Content: "hi"}},
+	}, 0)
+	if err != nil {
+		t.Fatalf("ChatSync: %v", err)
+	}
+	var answer models.Message
+	if err := db.First(&answer, result.AssistantMessageID).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.ModelName != "local/backup" || result.Model != "local/backup" {
+		t.Errorf("model = %q, result says %q, want the fallback that answered", answer.ModelName, result.Model)
+	}
+	if answer.Content != "from backup" {
+		t.Errorf("content = %q, want the fallback's answer", answer.Content)
+	}
+}
+
+// droppingProvider streams one chunk and then fails with a retryable error.
+type droppingProvider struct {
+	MockProvider
+	calls atomic.Int32
+}
+
+func (p *droppingProvider) StreamChat(ctx context.Context, req CompletionRequest, onChunk func(StreamResponse) error) (*UsageData, error) {
+	p.calls.Add(1)
+	if err := onChunk(StreamResponse{ID: "drop-1", Model: req.Model, Choices: []StreamChoice{{Delta: StreamDelta{Role: "assistant", Content: "partial"}}}}); err != nil {
+		return nil, err
+	}
+	return nil, &UpstreamError{StatusCode: 502, Message: "connection dropped"}
+}
+
+func TestChatDoesNotRetryAfterOutput(t *testing.T) {
+	db := testDB(t)
+	provider := &droppingProvider{}
+	s := NewChatService(db, provider)
+	s.RetryBaseDelay = time.Millisecond
+
+	w := httptest.NewRecorder()
+	if err := s.Chat(context.Background(), ChatRequest{
+		Model:          "mock/echo",
+		FallbackModels: []string{"mock/scripted"},
+		Messages:       []Message{{Role: "user", Content: "hi"}},
+	}, 0, w); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if calls := provider.calls.Load(); calls != 1 {
+		t.Errorf("upstream called %d times, want once", calls)
+	}
+	if !strings.Contains(w.Body.String(), "connection dropped") {
+		t.Errorf("stream has no error event: %s", w.Body.String())
+	}
+
+	var answer models.Message
+	if err := db.Where("role = ?", "assistant").First(&answer).Error; err != nil {
+		t.Fatalf("loading answer: %v", err)
+	}
+	if answer.Status != models.MessageStatusError || answer.Content != "partial" || answer.ModelName != "mock/echo" {
+		t.Errorf("answer status, content, model = %q, %q, %q", answer.Status, answer.Content, answer.ModelName)
+	}
+}
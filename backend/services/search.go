@@ -0,0 +1,212 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrEmptySearch is returned for a search query without any terms.
+var ErrEmptySearch = errors.New("search query is empty")
+
+// SearchPageSize is how many results a page of search results holds.
+const SearchPageSize = 20
+
+// SearchOptions narrows a message search. Nil and empty fields do not
+// filter. From and To bound CreatedAt as RFC 3339 timestamps, To exclusive.
+type SearchOptions struct {
+	Query   string
+	Role    string
+	Model   string
+	Starred *bool
+	Fork    *bool // Only messages of forks when true, of root chats when false
+	From    string
+	To      string
+	Page    int
+}
+
+// SearchResult is a message matching a search, with an HTML excerpt of its
+// content in which matches are wrapped in <mark> tags.
+type SearchResult struct {
+	MessageID uint   `json:"messageId"`
+	ChatID    uint   `json:"chatId"`
+	ParentID  *uint  `json:"parentId"` // Parent of the chat, when it is a fork
+	Role      string `json:"role"`
+	ModelName string `json:"modelName"`
+	Starred   bool   `json:"starred"`
+	CreatedAt string `json:"createdAt"`
+	Snippet   string `json:"snippet"`
+}
+
+// Snippets are built with these markers around matches, which are swapped
+// for <mark> tags once the text is escaped.
+const (
+	snippetStart = "\x01"
+	snippetStop  = "\x02"
+)
+
+// snippetHTML escapes a snippet and turns its markers into <mark> tags.
+func snippetHTML(snippet string) string {
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// searchIndex reports how message content is indexed: "postgres", "fts5"
+// when SQLite has the FTS5 index, or "" when the SQLite driver was built
+// without FTS5 and messages are scanned instead.
+func (s *ChatService) searchIndex() (string, error) {
+	if s.DB.Dialector.Name() == "postgres" {
+		return "postgres", nil
+	}
+	var count int64
+	if err := s.DB.Raw("SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'").Scan(&count).Error; err != nil {
+		return "", fmt.Errorf("error inspecting search index: %v", err)
+	}
+	if count == 0 {
+		return "", nil
+	}
+	return "fts5", nil
+}
+
+// likePattern matches content containing term, taking LIKE wildcards in it
+// literally.
+func likePattern(term string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term) + "%"
+}
+
+// scanSnippet cuts an excerpt of up to 24 words around the first word of
+// content matching one of terms, and marks every match in it. It stands in
+// for the snippets of the full-text index when messages are scanned.
+func scanSnippet(content string, terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	match := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	words := strings.Fields(content)
+	start := 0
+	for i, word := range words {
+		if match.MatchString(word) {
+			start = max(i-8, 0)
+			break
+		}
+	}
+	end := min(start+24, len(words))
+	excerpt := strings.Join(words[start:end], " ")
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(words) {
+		excerpt += "…"
+	}
+	return match.ReplaceAllString(excerpt, snippetStart+"$0"+snippetStop)
+}
+
+// ftsQuery turns free text into an SQLite full-text query matching messages
+// that contain every word. Each word is quoted so operators in the text are
+// taken literally; quotes are dropped.
+func ftsQuery(text string) string {
+	terms := strings.Fields(strings.ReplaceAll(text, `"`, " "))
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchMessages finds messages containing every word of opts.Query in
+// chats that are not deleted, best matches first, or newest first when
+// SQLite has no full-text index to rank them. It returns a page of results
+// and the total number of matches.
+func (s *ChatService) SearchMessages(opts SearchOptions) ([]SearchResult, int64, error) {
+	if ftsQuery(opts.Query) == "" {
+		return nil, 0, ErrEmptySearch
+	}
+	index, err := s.searchIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+	terms := strings.Fields(opts.Query)
+
+	query := s.DB.Table("messages").
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where("messages.deleted_at IS NULL AND chats.deleted_at IS NULL")
+	var snippet, rank clause.Expr
+	switch index {
+	case "postgres":
+		query = query.Where("to_tsvector('simple', messages.content) @@ plainto_tsquery('simple', ?)", opts.Query)
+		snippet = gorm.Expr("ts_headline('simple', messages.content, plainto_tsquery('simple', ?), ?)", opts.Query,
+			"StartSel="+snippetStart+", StopSel="+snippetStop+", MinWords=10, MaxWords=30")
+		rank = gorm.Expr("ts_rank(to_tsvector('simple', messages.content), plainto_tsquery('simple', ?)) DESC, messages.id DESC", opts.Query)
+	case "fts5":
+		query = query.Joins("JOIN messages_fts ON messages_fts.rowid = messages.id").Where("messages_fts MATCH ?", ftsQuery(opts.Query))
+		snippet = gorm.Expr("snippet(messages_fts, 0, ?, ?, '…', 24)", snippetStart, snippetStop)
+		rank = gorm.Expr("bm25(messages_fts), messages.id DESC")
+	default:
+		for _, term := range terms {
+			query = query.Where(`messages.content LIKE ? ESCAPE '\'`, likePattern(term))
+		}
+		snippet = gorm.Expr("messages.content")
+		rank = gorm.Expr("messages.id DESC")
+	}
+
+	if opts.Role != "" {
+		query = query.Where("messages.role = ?", opts.Role)
+	}
+	if opts.Model != "" {
+		query = query.Where("messages.model_name = ?", opts.Model)
+	}
+	if opts.Starred != nil {
+		query = query.Where("messages.starred = ?", *opts.Starred)
+	}
+	if opts.Fork != nil {
+		if *opts.Fork {
+			query = query.Where("chats.parent_id IS NOT NULL")
+		} else {
+			query = query.Where("chats.parent_id IS NULL")
+		}
+	}
+	// Timestamps are stored as text with the offset of the zone they were
+	// written in, so they are compared as instants rather than as strings
+	createdAt, bound := "datetime(messages.created_at)", "datetime(?)"
+	if index == "postgres" {
+		createdAt, bound = "messages.created_at::timestamptz", "?::timestamptz"
+	}
+	if opts.From != "" {
+		query = query.Where(createdAt+" >= "+bound, opts.From)
+	}
+	if opts.To != "" {
+		query = query.Where(createdAt+" < "+bound, opts.To)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("error counting search results: %v", err)
+	}
+
+	page := opts.Page
+	if page < 1 {
+		page = 1
+	}
+	results := []SearchResult{}
+	if err := query.
+		Select("messages.id AS message_id, messages.chat_id, chats.parent_id, messages.role, messages.model_name, messages.starred, messages.created_at, ? AS snippet", snippet).
+		Clauses(clause.OrderBy{Expression: rank}).
+		Limit(SearchPageSize).
+		Offset((page - 1) * SearchPageSize).
+		Scan(&results).Error; err != nil {
+		return nil, 0, fmt.Errorf("error searching messages: %v", err)
+	}
+	for i := range results {
+		if index == "" {
+			results[i].Snippet = scanSnippet(results[i].Snippet, terms)
+		}
+		results[i].Snippet = snippetHTML(results[i].Snippet)
+	}
+	return results, total, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"testing"
+
+	"web/ai-playground/models"
+
+	"gorm.io/gorm"
+)
+
+// dropSearchIndex removes the SQLite full-text index, leaving the database
+// as a build without FTS5 migrates it.
+func dropSearchIndex(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	for _, statement := range []string{
+		"DROP TRIGGER IF EXISTS messages_fts_after_insert",
+		"DROP TRIGGER IF EXISTS messages_fts_after_update",
+		"DROP TRIGGER IF EXISTS messages_fts_after_delete",
+		"DROP TABLE IF EXISTS messages_fts",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+// searchFixture saves a chat with messages, given as pairs of content and
+// creation time, in order.
+func searchFixture(t *testing.T, db *gorm.DB, messages ...[2]string) map[string]uint {
+	t.Helper()
+	chat := models.Chat{}
+	if err := db.Create(&chat).Error; err != nil {
+		t.Fatalf("creating chat: %v", err)
+	}
+	ids := make(map[string]uint)
+	for _, m := range messages {
+		content, createdAt := m[0], m[1]
+		message := models.Message{ChatID: chat.ID, Role: "user", Content: content}
+		if err := db.Create(&message).Error; err != nil {
+			t.Fatalf("creating message: %v", err)
+		}
+		if err := db.Model(&message).UpdateColumn("created_at", createdAt).Error; err != nil {
+			t.Fatalf("setting created_at: %v", err)
+		}
+		ids[content] = message.ID
+	}
+	return ids
+}
+
+func TestSearchMessagesWithoutIndex(t *testing.T) {
+	db := testDB(t)
+	dropSearchIndex(t, db)
+	s := NewChatService(db)
+	ids := searchFixture(t, db,
+		[2]string{"Hello <b>world</b>, 100% sure", "2026-01-01T10:00:00Z"},
+		[2]string{"hello there", "2026-01-02T10:00:00Z"},
+		[2]string{"a 1_0 literal underscore world", "2026-01-03T10:00:00Z"},
+	)
+
+	if index, err := s.searchIndex(); err != nil || index != "" {
+		t.Fatalf("searchIndex = %q, %v, want no index", index, err)
+	}
+
+	tests := []struct {
+		query string
+		want  []uint
+	}{
+		{"hello world", []uint{ids["Hello <b>world</b>, 100% sure"]}},
+		{"HELLO", []uint{ids["hello there"], ids["Hello <b>world</b>, 100% sure"]}},
+		{"100%", []uint{ids["Hello <b>world</b>, 100% sure"]}},
+		{"1_0", []uint{ids["a 1_0 literal underscore world"]}},
+		{"100_", nil},
+	}
+	for _, tt := range tests {
+		results, total, err := s.SearchMessages(SearchOptions{Query: tt.query})
+		if err != nil {
+			t.Fatalf("SearchMessages(%q): %v", tt.query, err)
+		}
+		var got []uint
+		for _, r := range results {
+			got = append(got, r.MessageID)
+		}
+		if total != int64(len(tt.want)) || len(got) != len(tt.want) {
+			t.Errorf("SearchMessages(%q) = %v (total %d), want %v", tt.query, got, total, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("SearchMessages(%q) = %v, want %v newest first", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+
+	results, _, err := s.SearchMessages(SearchOptions{Query: "world sure"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("SearchMessages = %v, %v", results, err)
+	}
+	if want := "Hello &lt;b&gt;<mark>world</mark>&lt;/b&gt;, 100% <mark>sure</mark>"; results[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", results[0].Snippet, want)
+	}
+}
+
+func TestSearchMessagesDateBounds(t *testing.T) {
+	for _, indexed := range []bool{true, false} {
+		name := "scan"
+		if indexed {
+			name = "index"
+		}
+		t.Run(name, func(t *testing.T) {
+			db := testDB(t)
+			s := NewChatService(db)
+			if !indexed {
+				dropSearchIndex(t, db)
+			} else if index, _ := s.searchIndex(); index == "" {
+				t.Skip("the SQLite driver was built without FTS5; run with -tags sqlite_fts5")
+			}
+			// 23:30 UTC on March 28th, stored with the offset of the zone it
+			// was written in
+			searchFixture(t, db, [2]string{"late night", "2026-03-29T01:30:00+02:00"})
+
+			tests := []struct {
+				from, to string
+				want     int64
+			}{
+				{"2026-03-28T23:00:00Z", "2026-03-29T00:00:00Z", 1},
+				{"2026-03-29T00:00:00+01:00", "", 1},
+				{"2026-03-29T00:00:00Z", "", 0},
+				{"", "2026-03-28T23:30:00Z", 0},
+				{"", "2026-03-29T01:30:01+02:00", 1},
+			}
+			for _, tt := range tests {
+				_, total, err := s.SearchMessages(SearchOptions{Query: "night", From: tt.from, To: tt.to})
+				if err != nil {
+					t.Fatalf("SearchMessages: %v", err)
+				}
+				if total != tt.want {
+					t.Errorf("from %q to %q matched %d, want %d", tt.from, tt.to, total, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchMessagesEmptyQuery(t *testing.T) {
+	s := NewChatService(testDB(t))
+	if _, _, err := s.SearchMessages(SearchOptions{Query: ` " `}); err != ErrEmptySearch {
+		t.Errorf("err = %v, want ErrEmptySearch", err)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Events streamed to the client by POST /api/chat and
+// GET /api/message/:id/stream. Each is sent as a server-sent event whose
+// `event:` field is the type below, whose `data:` field is the matching JSON
+// payload and whose `id:` field is its sequence number within the
+// generation. The sequence is the same for every provider:
+//
+//	message.created  once for every message saved: those of the request, the
+//	                 assistant message, and each tool message and later
+//	                 assistant message of a tool round
+//	reasoning        zero or more pieces of the model's thinking, before or
+//	                 between deltas
+//	delta            zero or more pieces of assistant content
+//	usage            token usage, when the provider reports it
+//	tool_call        the assistant asked to run a tool
+//	tool_result      a tool ran; its message.created (role "tool") comes
+//	                 first, and once every call has a result a new assistant
+//	                 message is created and the sequence starts over
+//	error            generation failed and the stream ends; no done follows
+//	done             the assistant message is complete
+const (
+	EventMessageCreated = "message.created"
+	EventDelta          = "delta"
+	EventReasoning      = "reasoning" // Carries a DeltaEvent
+	EventUsage          = "usage"
+	EventToolCall       = "tool_call"
+	EventToolResult     = "tool_result"
+	EventError          = "error"
+	EventDone           = "done"
+)
+
+type MessageCreatedEvent struct {
+	MessageID uint   `json:"messageId"`
+	ChatID    uint   `json:"chatId"`
+	Role      string `json:"role"`
+}
+
+type DeltaEvent struct {
+	MessageID uint   `json:"messageId"`
+	Content   string `json:"content"`
+}
+
+type UsageEvent struct {
+	MessageID        uint `json:"messageId"`
+	PromptTokens     int  `json:"promptTokens"`
+	CompletionTokens int  `json:"completionTokens"`
+	ReasoningTokens  int  `json:"reasoningTokens,omitempty"`
+	TotalTokens      int  `json:"totalTokens"`
+}
+
+type ToolCallEvent struct {
+	MessageID  uint   `json:"messageId"` // Assistant message making the call
+	ToolCallID string `json:"toolCallId"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+}
+
+type ToolResultEvent struct {
+	MessageID       uint   `json:"messageId"` // Tool message holding the result
+	CallerMessageID uint   `json:"callerMessageId"`
+	ToolCallID      string `json:"toolCallId"`
+	Name            string `json:"name"`
+	Content         string `json:"content"`
+}
+
+// Error event types, telling clients whether retrying may help.
+const (
+	ErrorTypeProvider = "provider" // The provider failed or could not be reached
+	ErrorTypeInternal = "internal" // The server failed to run or save the generation
+)
+
+type ErrorEvent struct {
+	MessageID  uint   `json:"messageId"`
+	Type       string `json:"type"`
+	Error      string `json:"error"`
+	StatusCode int    `json:"statusCode,omitempty"` // Upstream HTTP status, for provider errors
+}
+
+type DoneEvent struct {
+	MessageID    uint   `json:"messageId"`
+	FinishReason string `json:"finishReason"`
+	Model        string `json:"model,omitempty"` // Model that answered, which may be a fallback
+}
+
+// bufferedEvent is an event kept by a generation for replay. Seq numbers
+// start at 1 and are sent as the SSE `id:` field.
+type bufferedEvent struct {
+	Seq  int
+	Type string
+	Data []byte
+}
+
+// writeBufferedEvent sends a single event and flushes it to the client.
+func writeBufferedEvent(w io.Writer, event bufferedEvent) error {
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, event.Data); err != nil {
+		return fmt.Errorf("error writing %s event: %v", event.Type, err)
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
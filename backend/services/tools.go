@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Tool is a server-side function the model can call. Parameters is the JSON
+// schema of its arguments, and Run receives them as sent by the model.
+type Tool struct {
+	Name        string                                                          `json:"name"`
+	Description string                                                          `json:"description"`
+	Parameters  json.RawMessage                                                 `json:"parameters"`
+	Run         func(ctx context.Context, args json.RawMessage) (string, error) `json:"-"`
+}
+
+// ToolRegistry holds the tools chats can enable by name.
+type ToolRegistry struct {
+	tools map[string]Tool
+}
+
+func NewToolRegistry(tools ...Tool) *ToolRegistry {
+	r := &ToolRegistry{tools: make(map[string]Tool)}
+	for _, t := range tools {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register adds or replaces a tool under its name.
+func (r *ToolRegistry) Register(t Tool) {
+	r.tools[t.Name] = t
+}
+
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// List returns every registered tool, sorted by name.
+func (r *ToolRegistry) List() []Tool {
+	list := make([]Tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Resolve looks up the named tools, failing on the first unknown name.
+func (r *ToolRegistry) Resolve(names []string) ([]Tool, error) {
+	tools := make([]Tool, 0, len(names))
+	for _, name := range names {
+		t, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool: %q", name)
+		}
+		tools = append(tools, t)
+	}
+	return tools, nil
+}
+
+// toolDefinitions describes tools the way they are sent upstream.
+func toolDefinitions(tools []Tool) []ToolDefinition {
+	var defs []ToolDefinition
+	for _, t := range tools {
+		defs = append(defs, ToolDefinition{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			},
+		})
+	}
+	return defs
+}
+
+// BuiltinTools returns the tools available out of the box.
+func BuiltinTools() []Tool {
+	return []Tool{
+		{
+			Name:        "current_time",
+			Description: "Returns the current date and time, optionally in a given IANA time zone such as Europe/Paris.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA time zone name, UTC if omitted"}}}`),
+			Run:         runCurrentTime,
+		},
+		{
+			Name:        "calculator",
+			Description: "Evaluates an arithmetic expression with + - * / ^ and parentheses, e.g. (2 + 3) * 4.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"The expression to evaluate"}},"required":["expression"]}`),
+			Run:         runCalculator,
+		},
+	}
+}
+
+func runCurrentTime(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Timezone string `json:"timezone"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &params); err != nil {
+			return "", fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+	loc := time.UTC
+	if params.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(params.Timezone); err != nil {
+			return "", fmt.Errorf("unknown time zone %q", params.Timezone)
+		}
+	}
+	return time.Now().In(loc).Format(time.RFC1123Z), nil
+}
+
+func runCalculator(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	value, err := evalExpression(params.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), nil
+}
+
+// evalExpression evaluates an arithmetic expression by recursive descent.
+func evalExpression(expr string) (float64, error) {
+	p := &exprParser{input: strings.TrimSpace(expr)}
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return value, nil
+}
+
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of the input.
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (float64, error) {
+	left, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *exprParser) product() (float64, error) {
+	left, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		if op == '*' {
+			left *= right
+		} else if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		} else {
+			left /= right
+		}
+	}
+}
+
+func (p *exprParser) power() (float64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	// Right-associative: 2^3^2 is 2^(3^2)
+	exponent, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+func (p *exprParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.unary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.unary()
+	case '(':
+		p.pos++
+		value, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UpstreamClient performs HTTP calls to providers. Besides the request
+// context it enforces a connect timeout, a response timeout for responses
+// that are not streamed, which arrive only once the whole answer is
+// generated, and an idle timeout: the longest gap allowed between reads of
+// any body, and before a streamed response starts.
+type UpstreamClient struct {
+	Client          *http.Client
+	IdleTimeout     time.Duration
+	ResponseTimeout time.Duration
+}
+
+// ErrResponseTimeout is returned when a response that is not streamed does
+// not start within ResponseTimeout. It is not retried, as the upstream would
+// have to generate the whole answer again.
+var ErrResponseTimeout = errors.New("upstream response timed out")
+
+// DefaultUpstreamClient is used by providers that have no client of their own.
+var DefaultUpstreamClient = NewUpstreamClient(10*time.Second, 60*time.Second, 10*time.Minute)
+
+func upstreamClient(c *UpstreamClient) *UpstreamClient {
+	if c != nil {
+		return c
+	}
+	return DefaultUpstreamClient
+}
+
+func NewUpstreamClient(connectTimeout, idleTimeout, responseTimeout time.Duration) *UpstreamClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	return &UpstreamClient{
+		Client:          &http.Client{Transport: transport},
+		IdleTimeout:     idleTimeout,
+		ResponseTimeout: responseTimeout,
+	}
+}
+
+// NewUpstreamClientFromEnv reads UPSTREAM_CONNECT_TIMEOUT,
+// UPSTREAM_IDLE_TIMEOUT and UPSTREAM_RESPONSE_TIMEOUT as Go durations
+// (e.g. "10s"), keeping the defaults for unset values.
+func NewUpstreamClientFromEnv() (*UpstreamClient, error) {
+	connectTimeout, err := envDuration("UPSTREAM_CONNECT_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout, err := envDuration("UPSTREAM_IDLE_TIMEOUT", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	responseTimeout, err := envDuration("UPSTREAM_RESPONSE_TIMEOUT", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	return NewUpstreamClient(connectTimeout, idleTimeout, responseTimeout), nil
+}
+
+// Do sends a request whose response arrives whole, bound to ctx. The
+// response must start within ResponseTimeout, and its body must not stall
+// for IdleTimeout. The body must be closed by the caller.
+func (u *UpstreamClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	resp, err := u.send(ctx, cancel, req, u.ResponseTimeout, fmt.Errorf("%w: no response within %s", ErrResponseTimeout, u.ResponseTimeout))
+	if err != nil {
+		return nil, err
+	}
+	u.watchBody(resp, cancel)
+	return resp, nil
+}
+
+// Stream sends a request whose response is streamed, bound to ctx. The
+// request is cancelled if no data arrives for IdleTimeout, whether waiting
+// for the response or reading its body. The body must be closed by the
+// caller.
+func (u *UpstreamClient) Stream(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	resp, err := u.send(ctx, cancel, req, u.IdleTimeout, &idleError{timeout: u.IdleTimeout})
+	if err != nil {
+		return nil, err
+	}
+	u.watchBody(resp, cancel)
+	return resp, nil
+}
+
+// watchBody makes closing the body release the request, which is cancelled
+// if the body stalls for IdleTimeout, when set.
+func (u *UpstreamClient) watchBody(resp *http.Response, cancel context.CancelCauseFunc) {
+	if u.IdleTimeout > 0 {
+		resp.Body = newIdleTimeoutBody(resp.Body, u.IdleTimeout, func() { cancel(nil) })
+	} else {
+		resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: func() { cancel(nil) }}
+	}
+}
+
+// send performs the request on ctx, cancelling it with timeoutErr if the
+// response has not started after timeout, which is unlimited when 0.
+func (u *UpstreamClient) send(ctx context.Context, cancel context.CancelCauseFunc, req *http.Request, timeout time.Duration, timeoutErr error) (*http.Response, error) {
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(timeoutErr) })
+	}
+	resp, err := u.Client.Do(req.WithContext(ctx))
+	if timer != nil && !timer.Stop() {
+		// The timeout fired, possibly just as the response came in
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, timeoutErr
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// idleError reports an upstream that sent nothing for too long. It is a
+// net.Error, so a stream that never started is retried like a dropped
+// connection.
+type idleError struct {
+	timeout time.Duration
+}
+
+func (e *idleError) Error() string {
+	return fmt.Sprintf("no data received from upstream for %s", e.timeout)
+}
+
+func (e *idleError) Timeout() bool   { return true }
+func (e *idleError) Temporary() bool { return true }
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// idleTimeoutBody cancels the request when the body goes quiet for too long.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  func()
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel func()) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.timedOut = true
+		b.mu.Unlock()
+		cancel()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		timedOut := b.timedOut
+		b.mu.Unlock()
+		if timedOut {
+			return n, &idleError{timeout: b.timeout}
+		}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
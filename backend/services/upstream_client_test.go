@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingServer sends the given pieces, flushing each after its delay, then
+// holds the connection open until the client goes away.
+func stallingServer(t *testing.T, headerDelay time.Duration, pieces ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(headerDelay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for _, piece := range pieces {
+			fmt.Fprint(w, piece)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	server := stallingServer(t, 0, "data: first\n\n")
+	client := NewUpstreamClient(time.Second, 50*time.Millisecond, time.Second)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	data, err := io.ReadAll(resp.Body)
+	if string(data) != "data: first\n\n" {
+		t.Errorf("read %q before the timeout, want the first event", data)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("err = %v, want an idle timeout", err)
+	}
+	if !isRetryable(err) {
+		t.Error("idle timeout is not retryable")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("idle timeout took %s", elapsed)
+	}
+}
+
+func TestStreamIdleTimeoutBeforeResponse(t *testing.T) {
+	server := stallingServer(t, time.Second)
+	client := NewUpstreamClient(time.Second, 50*time.Millisecond, time.Second)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := client.Stream(context.Background(), req)
+	var idleErr *idleError
+	if !errors.As(err, &idleErr) {
+		t.Fatalf("err = %v, want an idle timeout", err)
+	}
+}
+
+func TestStreamSteadyDataOutlastsIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	client := NewUpstreamClient(time.Second, 100*time.Millisecond, time.Second)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("reading a steady stream: %v", err)
+	}
+}
+
+func TestDoResponseTimeout(t *testing.T) {
+	server := stallingServer(t, time.Second)
+	client := NewUpstreamClient(time.Second, time.Second, 50*time.Millisecond)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := client.Do(context.Background(), req)
+	if !errors.Is(err, ErrResponseTimeout) {
+		t.Fatalf("err = %v, want ErrResponseTimeout", err)
+	}
+	if isRetryable(err) {
+		t.Error("response timeout is retryable")
+	}
+}
+
+func TestDoBodyIdleTimeout(t *testing.T) {
+	server := stallingServer(t, 0, `{"id":`)
+	client := NewUpstreamClient(time.Second, 50*time.Millisecond, time.Second)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	_, err = io.ReadAll(resp.Body)
+	var idleErr *idleError
+	if !errors.As(err, &idleErr) {
+		t.Fatalf("err = %v, want an idle timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("idle timeout took %s", elapsed)
+	}
+}
+
+func TestDoCancelledByContext(t *testing.T) {
+	server := stallingServer(t, time.Second)
+	client := NewUpstreamClient(time.Second, time.Second, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := client.Do(ctx, req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context's deadline", err)
+	}
+}
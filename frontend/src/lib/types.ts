@@ -3,14 +3,19 @@ export interface Message {
   chatId: number;
   role: string;
   content: string;
+  reasoning?: string;
   createdAt: string;
   updatedAt: string;
   deletedAt: string | null;
   modelName: string;
   starred: boolean;
+  status?: MessageStatus;
+  error?: string;
   tokenUsage?: TokenUsage;
 }
 
+export type MessageStatus = 'streaming' | 'complete' | 'error' | 'cancelled';
+
 export interface Chat {
   id: number;
   messages: Message[];
@@ -18,6 +23,7 @@ export interface Chat {
   updatedAt: string;
   deletedAt: string | null;
   modelName: string;
+  systemPrompt?: string;
   starred: boolean;
   parentId?: number | null;
   forkMessageID?: number;
@@ -27,9 +33,12 @@ export interface Chat {
 type NewMessage = {
   role: string;
   content: string;
+  reasoning?: string;
   id?: number;
   modelName?: string;
   starred?: boolean;
+  status?: MessageStatus;
+  error?: string;
   tokenUsage?: TokenUsage;
 };
 